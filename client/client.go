@@ -0,0 +1,553 @@
+// Package client is the Go client of the database service WebSocket API.
+//
+// A Client keeps one connection to the server and reconnects with
+// exponential backoff when it is lost, restoring its subscriptions. Calls
+// may be made from several goroutines; each one is matched to its response
+// by request ID and bounded by its context.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chidi150c/database/model"
+	"github.com/gorilla/websocket"
+)
+
+var (
+	// ErrClosed is returned by calls on a closed Client.
+	ErrClosed = errors.New("client: closed")
+	// ErrDisconnected is returned when the connection is lost while a call
+	// waits for its response. The server may or may not have applied it.
+	ErrDisconnected = errors.New("client: connection lost")
+)
+
+// ErrorCode returns the code of the error the server answered with, one of
+// the model.Code* constants, or "" if err is not a *model.ResponseError.
+func ErrorCode(err error) string {
+	var re *model.ResponseError
+	if errors.As(err, &re) {
+		return re.Code
+	}
+	return ""
+}
+
+// Event is a change pushed to a subscribed connection.
+type Event struct {
+	Event  string                   `json:"event"`
+	Entity string                   `json:"entity"`
+	DataID uint                     `json:"data_id"`
+	Data   *model.TradingSystemData `json:"data"`
+}
+
+// SubscribeRequest selects the changes to subscribe to: one trading system
+// by ID, every trading system of a symbol, or all of them.
+type SubscribeRequest struct {
+	ID     uint   `json:"id,omitempty"`
+	Symbol string `json:"symbol,omitempty"`
+	All    bool   `json:"all,omitempty"`
+}
+
+// Config configures a Client. Only URL is required.
+type Config struct {
+	// URL is the WebSocket endpoint, e.g.
+	// ws://localhost:35261/database-services/ws.
+	URL string
+	// APIKey is sent as a bearer token when set.
+	APIKey string
+	// Header is sent with every connection attempt.
+	Header http.Header
+	// Dialer defaults to websocket.DefaultDialer.
+	Dialer *websocket.Dialer
+	// MinBackoff and MaxBackoff bound the wait between reconnection
+	// attempts, which doubles after each failure. They default to 100ms
+	// and 10s.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// EventBuffer is the capacity of the Events channel, 100 by default.
+	// Events that arrive while it is full are dropped.
+	EventBuffer int
+}
+
+// keyActions maps the actions that do not apply to trading systems to their
+// entity.
+var keyActions = map[string]string{
+	"create-key": "api-key",
+	"list-keys":  "api-key",
+	"revoke-key": "api-key",
+}
+
+// Client is a connection to the database service.
+type Client struct {
+	cfg    Config
+	ctx    context.Context
+	cancel context.CancelFunc
+	events chan Event
+	done   chan struct{}
+
+	// writeMu serializes writes; gorilla/websocket allows one writer.
+	writeMu sync.Mutex
+
+	mu   sync.Mutex
+	conn *websocket.Conn
+	// ready is closed once conn is set; it is replaced when conn is lost.
+	ready   chan struct{}
+	seq     uint64
+	pending map[string]chan *model.Response
+	subs    map[SubscribeRequest]bool
+}
+
+// Dial connects to the server described by cfg. The first connection is
+// made before Dial returns, so a wrong URL fails here; later ones are
+// retried in the background until Close.
+func Dial(ctx context.Context, cfg Config) (*Client, error) {
+	if cfg.Dialer == nil {
+		cfg.Dialer = websocket.DefaultDialer
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = 100 * time.Millisecond
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = 10 * time.Second
+	}
+	if cfg.EventBuffer <= 0 {
+		cfg.EventBuffer = 100
+	}
+	if cfg.APIKey != "" {
+		header := cfg.Header.Clone()
+		if header == nil {
+			header = make(http.Header)
+		}
+		header.Set("Authorization", "Bearer "+cfg.APIKey)
+		cfg.Header = header
+	}
+	conn, resp, err := cfg.Dialer.DialContext(ctx, cfg.URL, cfg.Header)
+	if err != nil {
+		if resp != nil {
+			// The server refused the upgrade, e.g. for a bad API key.
+			return nil, fmt.Errorf("client: dial %s: %w: %s", cfg.URL, err, resp.Status)
+		}
+		return nil, fmt.Errorf("client: dial %s: %w", cfg.URL, err)
+	}
+	c := &Client{
+		cfg:     cfg,
+		events:  make(chan Event, cfg.EventBuffer),
+		done:    make(chan struct{}),
+		conn:    conn,
+		ready:   make(chan struct{}),
+		pending: make(map[string]chan *model.Response),
+		subs:    make(map[SubscribeRequest]bool),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	close(c.ready)
+	go c.run(conn)
+	return c, nil
+}
+
+// Events returns the changes pushed for the subscriptions of the client. It
+// is closed by Close.
+func (c *Client) Events() <-chan Event {
+	return c.events
+}
+
+// Close closes the connection and stops reconnecting. Calls in progress
+// return ErrClosed.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.ctx.Err() != nil {
+		c.mu.Unlock()
+		return nil
+	}
+	c.cancel()
+	conn := c.conn
+	c.mu.Unlock()
+	var err error
+	if conn != nil {
+		c.writeMu.Lock()
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		c.writeMu.Unlock()
+		err = conn.Close()
+	}
+	<-c.done
+	return err
+}
+
+// run reads from conn and, when it fails, reconnects until Close.
+func (c *Client) run(conn *websocket.Conn) {
+	defer close(c.done)
+	defer close(c.events)
+	for conn != nil {
+		c.read(conn)
+		c.disconnect()
+		conn = c.reconnect()
+	}
+}
+
+// read dispatches the messages of conn until it fails.
+func (c *Client) read(conn *websocket.Conn) {
+	for {
+		_, p, err := conn.ReadMessage()
+		if err != nil {
+			conn.Close()
+			return
+		}
+		resp := c.dispatchEvent(p)
+		if resp == nil {
+			continue
+		}
+		c.mu.Lock()
+		ch, ok := c.pending[resp.RequestID]
+		delete(c.pending, resp.RequestID)
+		c.mu.Unlock()
+		if ok {
+			ch <- resp
+		}
+	}
+}
+
+// dispatchEvent sends the message p to Events if it is an event, and
+// otherwise decodes it as a response.
+func (c *Client) dispatchEvent(p []byte) *model.Response {
+	var probe struct {
+		Event string `json:"event"`
+	}
+	if json.Unmarshal(p, &probe) == nil && probe.Event != "" {
+		var event Event
+		if json.Unmarshal(p, &event) == nil {
+			select {
+			case c.events <- event:
+			default:
+			}
+		}
+		return nil
+	}
+	// The data is left encoded until the caller says what it holds
+	resp := &model.Response{Data: new(json.RawMessage)}
+	if json.Unmarshal(p, resp) != nil {
+		return nil
+	}
+	return resp
+}
+
+// disconnect fails the calls waiting on the lost connection.
+func (c *Client) disconnect() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn = nil
+	c.ready = make(chan struct{})
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+}
+
+// reconnect dials with exponential backoff and restores the subscriptions.
+// It returns nil once the client is closed.
+func (c *Client) reconnect() *websocket.Conn {
+	backoff := c.cfg.MinBackoff
+	for {
+		// Sleep between half and all of the backoff so that clients
+		// dropped together do not reconnect together.
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		select {
+		case <-c.ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+		if conn, err := c.connect(); err == nil {
+			return conn
+		}
+		if backoff *= 2; backoff > c.cfg.MaxBackoff {
+			backoff = c.cfg.MaxBackoff
+		}
+	}
+}
+
+func (c *Client) connect() (*websocket.Conn, error) {
+	conn, _, err := c.cfg.Dialer.DialContext(c.ctx, c.cfg.URL, c.cfg.Header)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.resubscribe(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ctx.Err() != nil {
+		conn.Close()
+		return nil, ErrClosed
+	}
+	c.conn = conn
+	close(c.ready)
+	return conn, nil
+}
+
+// resubscribe restores the subscriptions on a new connection. Nobody else
+// uses conn before it is published, so it waits for every subscription to
+// be acknowledged: the server handles messages concurrently, and a call
+// sent right after could otherwise overtake them.
+func (c *Client) resubscribe(conn *websocket.Conn) error {
+	c.mu.Lock()
+	subs := make([]SubscribeRequest, 0, len(c.subs))
+	for req := range c.subs {
+		subs = append(subs, req)
+	}
+	c.mu.Unlock()
+	if len(subs) == 0 {
+		return nil
+	}
+	conn.SetReadDeadline(time.Now().Add(c.cfg.MaxBackoff))
+	defer conn.SetReadDeadline(time.Time{})
+	for i, req := range subs {
+		msg := map[string]interface{}{
+			"request_id": fmt.Sprintf("resubscribe-%d", i),
+			"action":     "subscribe",
+			"entity":     "trading-system",
+			"data":       req,
+		}
+		if err := conn.WriteJSON(msg); err != nil {
+			return err
+		}
+	}
+	for acked := 0; acked < len(subs); {
+		_, p, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		resp := c.dispatchEvent(p)
+		if resp == nil || !strings.HasPrefix(resp.RequestID, "resubscribe-") {
+			continue
+		}
+		if resp.Error != nil {
+			return resp.Error
+		}
+		acked++
+	}
+	return nil
+}
+
+// call sends a message for action to the entity it applies to, see Send.
+func (c *Client) call(ctx context.Context, action string, data, v interface{}) (*model.Response, error) {
+	entity, ok := keyActions[action]
+	if !ok {
+		entity = "trading-system"
+	}
+	return c.Send(ctx, action, entity, data, v)
+}
+
+// Send sends a message and waits for its response, decoding the response
+// data into v unless v is nil. A response with the error status is returned
+// along with its *model.ResponseError. The methods of Client cover the
+// common actions; Send reaches the others. While the client is reconnecting,
+// it waits for the connection within the deadline of ctx.
+func (c *Client) Send(ctx context.Context, action, entity string, data, v interface{}) (*model.Response, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	conn, requestID, ch, err := c.register(ctx)
+	if err != nil {
+		return nil, err
+	}
+	msg := map[string]interface{}{
+		"request_id": requestID,
+		"action":     action,
+		"entity":     entity,
+		"data":       json.RawMessage(raw),
+	}
+	deadline, _ := ctx.Deadline()
+	c.writeMu.Lock()
+	conn.SetWriteDeadline(deadline)
+	err = conn.WriteJSON(msg)
+	c.writeMu.Unlock()
+	if err != nil {
+		// A failed write leaves the connection unusable; closing it makes
+		// the read loop reconnect.
+		c.forget(requestID)
+		conn.Close()
+		return nil, fmt.Errorf("%w: %s: %v", ErrDisconnected, action, err)
+	}
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, ErrDisconnected
+		}
+		if resp.Status == model.StatusError && resp.Error != nil {
+			return resp, resp.Error
+		}
+		if data, _ := resp.Data.(*json.RawMessage); v != nil && data != nil && len(*data) > 0 {
+			if err := json.Unmarshal(*data, v); err != nil {
+				return resp, fmt.Errorf("client: decoding %s response: %w", action, err)
+			}
+		}
+		return resp, nil
+	case <-ctx.Done():
+		c.forget(requestID)
+		return nil, ctx.Err()
+	case <-c.ctx.Done():
+		return nil, ErrClosed
+	}
+}
+
+// register waits for a connection and reserves a request ID on it.
+func (c *Client) register(ctx context.Context) (*websocket.Conn, string, chan *model.Response, error) {
+	for {
+		c.mu.Lock()
+		if c.ctx.Err() != nil {
+			c.mu.Unlock()
+			return nil, "", nil, ErrClosed
+		}
+		if c.conn != nil {
+			c.seq++
+			requestID := fmt.Sprintf("%d", c.seq)
+			ch := make(chan *model.Response, 1)
+			c.pending[requestID] = ch
+			conn := c.conn
+			c.mu.Unlock()
+			return conn, requestID, ch, nil
+		}
+		ready := c.ready
+		c.mu.Unlock()
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return nil, "", nil, ctx.Err()
+		case <-c.ctx.Done():
+			return nil, "", nil, ErrClosed
+		}
+	}
+}
+
+func (c *Client) forget(requestID string) {
+	c.mu.Lock()
+	delete(c.pending, requestID)
+	c.mu.Unlock()
+}
+
+// CreateTradingSystem creates ts and returns its ID and revision.
+func (c *Client) CreateTradingSystem(ctx context.Context, ts *model.TradingSystemData) (id, revision uint, err error) {
+	resp, err := c.call(ctx, "create", ts, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	return resp.DataID, resp.Revision, nil
+}
+
+// ReadTradingSystem reads the trading system id; 0 reads the latest one.
+func (c *Client) ReadTradingSystem(ctx context.Context, id uint) (*model.TradingSystemData, error) {
+	var ts model.TradingSystemData
+	if _, err := c.call(ctx, "read", map[string]uint{"id": id}, &ts); err != nil {
+		return nil, err
+	}
+	return &ts, nil
+}
+
+// List returns one page of the trading systems selected by filter and the
+// cursor of the next page, "" on the last one.
+func (c *Client) List(ctx context.Context, filter model.TradingSystemFilter) ([]*model.TradingSystemData, string, error) {
+	var trades []*model.TradingSystemData
+	resp, err := c.call(ctx, "list", filter, &trades)
+	if err != nil {
+		return nil, "", err
+	}
+	return trades, resp.NextCursor, nil
+}
+
+// Update replaces the trading system ts.ID with ts and returns its new
+// revision. A non-zero ts.Revision fails with model.CodeConflict unless it is
+// the stored revision.
+func (c *Client) Update(ctx context.Context, ts *model.TradingSystemData) (revision uint, err error) {
+	resp, err := c.call(ctx, "update", ts, nil)
+	if err != nil {
+		return 0, err
+	}
+	return resp.Revision, nil
+}
+
+// Patch applies a JSON merge patch to the trading system id and returns its
+// new revision. Including "revision" in patch makes it a compare-and-swap.
+func (c *Client) Patch(ctx context.Context, id uint, patch map[string]interface{}) (revision uint, err error) {
+	data := make(map[string]interface{}, len(patch)+1)
+	for k, v := range patch {
+		data[k] = v
+	}
+	data["id"] = id
+	resp, err := c.call(ctx, "patch", data, nil)
+	if err != nil {
+		return 0, err
+	}
+	return resp.Revision, nil
+}
+
+// Delete deletes the trading system id.
+func (c *Client) Delete(ctx context.Context, id uint) error {
+	_, err := c.call(ctx, "delete", map[string]uint{"id": id}, nil)
+	return err
+}
+
+// Subscribe asks for the changes selected by req to be sent on Events. The
+// subscription is restored after a reconnection.
+func (c *Client) Subscribe(ctx context.Context, req SubscribeRequest) error {
+	if _, err := c.call(ctx, "subscribe", req, nil); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.subs[req] = true
+	c.mu.Unlock()
+	return nil
+}
+
+// Unsubscribe drops a subscription made with the same req; an empty req
+// drops them all.
+func (c *Client) Unsubscribe(ctx context.Context, req SubscribeRequest) error {
+	if _, err := c.call(ctx, "unsubscribe", req, nil); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	if req == (SubscribeRequest{}) {
+		c.subs = make(map[SubscribeRequest]bool)
+	} else {
+		delete(c.subs, req)
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+// CreateAPIKey creates an API key with the given scopes and returns it with
+// its token, which the server does not keep. It needs the admin scope.
+func (c *Client) CreateAPIKey(ctx context.Context, name string, scopes []string) (*model.APIKey, string, error) {
+	var created struct {
+		Key   *model.APIKey `json:"key"`
+		Token string        `json:"token"`
+	}
+	req := map[string]interface{}{"name": name, "scopes": scopes}
+	if _, err := c.call(ctx, "create-key", req, &created); err != nil {
+		return nil, "", err
+	}
+	return created.Key, created.Token, nil
+}
+
+// ListAPIKeys returns every API key, revoked or not. It needs the admin
+// scope.
+func (c *Client) ListAPIKeys(ctx context.Context) ([]*model.APIKey, error) {
+	var keys []*model.APIKey
+	if _, err := c.call(ctx, "list-keys", nil, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey revokes the API key id and disconnects its clients. It needs
+// the admin scope.
+func (c *Client) RevokeAPIKey(ctx context.Context, id uint) error {
+	_, err := c.call(ctx, "revoke-key", map[string]uint{"id": id}, nil)
+	return err
+}
@@ -0,0 +1,161 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chidi150c/database/client"
+	"github.com/chidi150c/database/model"
+	"github.com/chidi150c/database/servertest"
+	"github.com/gorilla/websocket"
+)
+
+func dial(t *testing.T, url string) *client.Client {
+	t.Helper()
+	c, err := client.Dial(context.Background(), client.Config{URL: url, MinBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func newTradingSystem() *model.TradingSystemData {
+	return &model.TradingSystemData{Symbol: "BTCUSDT", BaseCurrency: "BTC", QuoteCurrency: "USDT"}
+}
+
+func nextEvent(t *testing.T, c *client.Client) client.Event {
+	t.Helper()
+	select {
+	case event := <-c.Events():
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+	}
+	return client.Event{}
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	c := dial(t, servertest.NewServer(t).WebSocketURL())
+	if err := c.Subscribe(ctx, client.SubscribeRequest{Symbol: "BTCUSDT"}); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	id, rev, err := c.CreateTradingSystem(ctx, newTradingSystem())
+	if err != nil {
+		t.Fatalf("CreateTradingSystem: %v", err)
+	}
+	if event := nextEvent(t, c); event.Event != "created" || event.DataID != id {
+		t.Fatalf("got event %+v, want created %d", event, id)
+	}
+	ts, err := c.ReadTradingSystem(ctx, id)
+	if err != nil {
+		t.Fatalf("ReadTradingSystem: %v", err)
+	}
+	ts.TradeCount = 3
+	if rev, err = c.Update(ctx, ts); err != nil || rev != 2 {
+		t.Fatalf("Update returned revision %d, %v", rev, err)
+	}
+	if _, err = c.Update(ctx, ts); client.ErrorCode(err) != model.CodeConflict {
+		t.Fatalf("stale Update returned %v, want a conflict", err)
+	}
+	var cerr *model.ResponseError
+	if !errors.As(err, &cerr) || cerr.CurrentRevision != 2 {
+		t.Fatalf("conflict %v does not carry the current revision", err)
+	}
+	if rev, err = c.Patch(ctx, id, map[string]interface{}{"trade_count": 4}); err != nil || rev != 3 {
+		t.Fatalf("Patch returned revision %d, %v", rev, err)
+	}
+	trades, next, err := c.List(ctx, model.TradingSystemFilter{})
+	if err != nil || len(trades) != 1 || trades[0].TradeCount != 4 || next != "" {
+		t.Fatalf("List returned %v, %q, %v", trades, next, err)
+	}
+	// The actions without a method of their own go through Send
+	var history []*model.TradingSystemHistory
+	resp, err := c.Send(ctx, "history", "trading-system", model.HistoryQuery{ID: id}, &history)
+	if err != nil || resp.Status != model.StatusOK || len(history) != 3 || history[2].Revision != 3 {
+		t.Fatalf("Send history returned %+v, %v, %v", resp, history, err)
+	}
+	if _, err := c.Send(ctx, "history", "app-data", model.HistoryQuery{ID: id}, nil); client.ErrorCode(err) != model.CodeUnknownEntity {
+		t.Fatalf("Send to an unknown entity returned %v, want %s", err, model.CodeUnknownEntity)
+	}
+	if err := c.Delete(ctx, id); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := c.ReadTradingSystem(ctx, id); client.ErrorCode(err) != model.CodeNotFound {
+		t.Fatalf("ReadTradingSystem after Delete returned %v, want not found", err)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	ctx := context.Background()
+	s := servertest.NewServer(t)
+	c := dial(t, s.WebSocketURL())
+	if err := c.Subscribe(ctx, client.SubscribeRequest{All: true}); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	s.DropConnections()
+
+	// The call waits for the client to reconnect.
+	callCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	var (
+		id  uint
+		err error
+	)
+	for {
+		// A call sent before the client notices the drop fails and is
+		// retried.
+		id, _, err = c.CreateTradingSystem(callCtx, newTradingSystem())
+		if !errors.Is(err, client.ErrDisconnected) {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("CreateTradingSystem after reconnect: %v", err)
+	}
+	// The subscription is restored on the new connection.
+	if event := nextEvent(t, c); event.Event != "created" || event.DataID != id {
+		t.Fatalf("got event %+v, want created %d", event, id)
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	// A server that accepts requests and never answers.
+	upgrader := websocket.Upgrader{}
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer hs.Close()
+	c := dial(t, "ws"+strings.TrimPrefix(hs.URL, "http"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.ReadTradingSystem(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ReadTradingSystem returned %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	c.Close()
+	if _, err := c.ReadTradingSystem(context.Background(), 1); !errors.Is(err, client.ErrClosed) {
+		t.Fatalf("ReadTradingSystem after Close returned %v, want %v", err, client.ErrClosed)
+	}
+	if _, ok := <-c.Events(); ok {
+		t.Fatal("Events is not closed after Close")
+	}
+}
@@ -0,0 +1,397 @@
+// Package dbtest is the conformance suite every model.DBServicer
+// implementation must pass, so that the backends stay interchangeable.
+package dbtest
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/chidi150c/database/model"
+)
+
+// Run runs the behaviour every DBServicer backend must share against the
+// backends returned by newDBS, a fresh empty one per test. Backends that also
+// implement model.APIKeyServicer are checked for it too.
+func Run(t *testing.T, newDBS func(t *testing.T) model.DBServicer) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, dbs model.DBServicer)
+	}{
+		{"CreateRead", testCreateRead},
+		{"List", testList},
+		{"UpdateConflict", testUpdateConflict},
+		{"Patch", testPatch},
+		{"Prices", testPrices},
+		{"Delete", testDelete},
+		{"HistoryRevert", testHistoryRevert},
+		{"APIKeys", testAPIKeys},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newDBS(t))
+		})
+	}
+}
+
+func createTradingSystem(t *testing.T, dbs model.DBServicer, ts *model.TradingSystemData) *model.TradingSystem {
+	t.Helper()
+	trade := model.NewTradingSystem(ts)
+	if _, err := dbs.CreateTradingSystem(trade); err != nil {
+		t.Fatalf("CreateTradingSystem: %v", err)
+	}
+	return trade
+}
+
+func wantCode(t *testing.T, err error, code string) {
+	t.Helper()
+	if got := model.ErrorCode(err); got != code {
+		t.Fatalf("got error %v with code %q, want code %q", err, got, code)
+	}
+}
+
+// jsonString returns the JSON encoding of v, to compare values whatever type
+// the backend decoded them to.
+func jsonString(t *testing.T, v interface{}) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func testCreateRead(t *testing.T, dbs model.DBServicer) {
+	trade := createTradingSystem(t, dbs, &model.TradingSystemData{
+		Symbol:        "BTCUSDT",
+		ClosingPrices: []float64{1.5, 2.5},
+		Timestamps:    []int64{10, 20},
+		Signals:       []string{"buy", "sell"},
+		QuoteBalance:  100,
+		InTrade:       true,
+	})
+	if trade.ID == 0 || trade.Revision != 1 {
+		t.Fatalf("created ID %d revision %d, want an ID at revision 1", trade.ID, trade.Revision)
+	}
+	latest := createTradingSystem(t, dbs, &model.TradingSystemData{Symbol: "ETHUSDT"})
+
+	got, err := dbs.ReadTradingSystem(trade.ID)
+	if err != nil {
+		t.Fatalf("ReadTradingSystem: %v", err)
+	}
+	if !reflect.DeepEqual(got.Data(), trade.Data()) {
+		t.Fatalf("read %+v, want %+v", got.Data(), trade.Data())
+	}
+	if got, err := dbs.ReadTradingSystem(0); err != nil || got.ID != latest.ID {
+		t.Fatalf("ReadTradingSystem(0) = %v, %v, want the latest trading system %d", got, err, latest.ID)
+	}
+	_, err = dbs.ReadTradingSystem(latest.ID + 100)
+	wantCode(t, err, model.CodeNotFound)
+}
+
+func testList(t *testing.T, dbs model.DBServicer) {
+	for i, symbol := range []string{"BTC", "ETH", "BTC", "BTC", "ETH"} {
+		createTradingSystem(t, dbs, &model.TradingSystemData{Symbol: symbol, QuoteBalance: float64(i)})
+	}
+	symbol := "BTC"
+	filter := model.TradingSystemFilter{Symbol: &symbol, SortBy: "quote_balance", Order: "desc", Limit: 2}
+	var balances []float64
+	for page := 0; ; page++ {
+		trades, next, err := dbs.ListTradingSystems(filter)
+		if err != nil {
+			t.Fatalf("ListTradingSystems: %v", err)
+		}
+		for _, trade := range trades {
+			balances = append(balances, trade.QuoteBalance)
+		}
+		if next == "" {
+			break
+		}
+		if page > 2 {
+			t.Fatalf("ListTradingSystems does not stop paging")
+		}
+		filter.Cursor = next
+	}
+	if want := []float64{3, 2, 0}; !reflect.DeepEqual(balances, want) {
+		t.Fatalf("listed balances %v, want %v", balances, want)
+	}
+
+	// The time bounds compare instants, whatever zone they are given in.
+	for _, offset := range []int{-5, 5} {
+		zone := time.FixedZone("", offset*3600)
+		after, before := time.Now().Add(-time.Minute).In(zone), time.Now().Add(time.Minute).In(zone)
+		for _, filter := range []model.TradingSystemFilter{
+			{CreatedAfter: &after, CreatedBefore: &before},
+			{UpdatedAfter: &after, UpdatedBefore: &before},
+		} {
+			trades, _, err := dbs.ListTradingSystems(filter)
+			if err != nil || len(trades) != 5 {
+				t.Fatalf("ListTradingSystems between %s and %s returned %d systems, %v, want 5", after, before, len(trades), err)
+			}
+		}
+		trades, _, err := dbs.ListTradingSystems(model.TradingSystemFilter{CreatedBefore: &after})
+		if err != nil || len(trades) != 0 {
+			t.Fatalf("ListTradingSystems before %s returned %d systems, %v, want none", after, len(trades), err)
+		}
+	}
+
+	_, _, err := dbs.ListTradingSystems(model.TradingSystemFilter{SortBy: "nope"})
+	wantCode(t, err, model.CodeInvalidPayload)
+}
+
+func testUpdateConflict(t *testing.T, dbs model.DBServicer) {
+	trade := createTradingSystem(t, dbs, &model.TradingSystemData{Symbol: "BTC", QuoteBalance: 100})
+
+	update := model.NewTradingSystem(&model.TradingSystemData{Revision: 1, Symbol: "BTC", QuoteBalance: 90})
+	update.ID = trade.ID
+	if err := dbs.UpdateTradingSystem(update); err != nil {
+		t.Fatalf("UpdateTradingSystem: %v", err)
+	}
+	if update.Revision != 2 || update.QuoteBalance != 90 {
+		t.Fatalf("updated to revision %d balance %v, want revision 2 balance 90", update.Revision, update.QuoteBalance)
+	}
+
+	stale := model.NewTradingSystem(&model.TradingSystemData{Revision: 1, Symbol: "BTC", QuoteBalance: 80})
+	stale.ID = trade.ID
+	err := dbs.UpdateTradingSystem(stale)
+	wantCode(t, err, model.CodeConflict)
+	if e, ok := err.(*model.Error); !ok || e.Revision != 2 {
+		t.Fatalf("conflict error %#v does not carry the current revision 2", err)
+	}
+}
+
+func testPatch(t *testing.T, dbs model.DBServicer) {
+	trade := createTradingSystem(t, dbs, &model.TradingSystemData{Symbol: "BTC", QuoteBalance: 100, TradingLevel: 3})
+
+	patched, err := dbs.PatchTradingSystem(trade.ID, []byte(`{"quote_balance": 50}`))
+	if err != nil {
+		t.Fatalf("PatchTradingSystem: %v", err)
+	}
+	if patched.QuoteBalance != 50 || patched.TradingLevel != 3 || patched.Revision != 2 {
+		t.Fatalf("patched to %+v, want balance 50, level 3 at revision 2", patched.Data())
+	}
+	_, err = dbs.PatchTradingSystem(trade.ID, []byte(`{"no_such_field": 1}`))
+	wantCode(t, err, model.CodeInvalidPayload)
+	_, err = dbs.PatchTradingSystem(trade.ID, []byte(`{"revision": 1, "quote_balance": 1}`))
+	wantCode(t, err, model.CodeConflict)
+}
+
+func testPrices(t *testing.T, dbs model.DBServicer) {
+	trade := createTradingSystem(t, dbs, &model.TradingSystemData{Symbol: "BTC", MaxDataSize: 3})
+	for _, points := range [][]model.PricePoint{
+		{{Timestamp: 10, Price: 1}, {Timestamp: 20, Price: 2}},
+		{{Timestamp: 70, Price: 7}, {Timestamp: 80, Price: 8}},
+	} {
+		if _, err := dbs.AppendPrices(trade.ID, points); err != nil {
+			t.Fatalf("AppendPrices: %v", err)
+		}
+	}
+	got, err := dbs.ReadTradingSystem(trade.ID)
+	if err != nil {
+		t.Fatalf("ReadTradingSystem: %v", err)
+	}
+	if want := []float64{2, 7, 8}; !reflect.DeepEqual([]float64(got.ClosingPrices), want) {
+		t.Fatalf("closing prices %v, want %v trimmed to max_data_size", got.ClosingPrices, want)
+	}
+
+	// The history records the points appended and trimmed, not the arrays,
+	// and the earlier revisions are still rebuilt from it.
+	entries, _, err := dbs.ListHistory(model.HistoryQuery{ID: trade.ID, Cursor: "2"})
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("ListHistory = %v, %v, want the second append", entries, err)
+	}
+	var appended *model.FieldChange
+	for i, c := range entries[0].Changes {
+		if c.Field == "closing_prices" {
+			appended = &entries[0].Changes[i]
+		}
+	}
+	if appended == nil || appended.Old != nil || appended.New != nil ||
+		jsonString(t, appended.Appended) != "[7,8]" || jsonString(t, appended.Dropped) != "[1]" {
+		t.Fatalf("append recorded as %+v, want 7 and 8 appended and 1 dropped", appended)
+	}
+	for revision, want := range map[uint][]float64{1: nil, 2: {1, 2}} {
+		old, err := dbs.ReadTradingSystemRevision(trade.ID, revision)
+		if err != nil {
+			t.Fatalf("ReadTradingSystemRevision(%d): %v", revision, err)
+		}
+		if len(old.ClosingPrices) != len(want) || (len(want) > 0 && !reflect.DeepEqual([]float64(old.ClosingPrices), want)) {
+			t.Fatalf("revision %d has closing prices %v, want %v", revision, old.ClosingPrices, want)
+		}
+	}
+
+	ticks, err := dbs.ReadPrices(model.PriceQuery{Symbol: "BTC"})
+	if err != nil {
+		t.Fatalf("ReadPrices: %v", err)
+	}
+	if len(ticks) != 4 {
+		t.Fatalf("read %d ticks, want the 4 appended", len(ticks))
+	}
+	ticks, err = dbs.ReadPrices(model.PriceQuery{Symbol: "BTC", From: 15, Interval: 60})
+	if err != nil {
+		t.Fatalf("ReadPrices: %v", err)
+	}
+	var timestamps []int64
+	for _, tick := range ticks {
+		timestamps = append(timestamps, tick.Timestamp)
+	}
+	if want := []int64{20, 80}; !reflect.DeepEqual(timestamps, want) {
+		t.Fatalf("downsampled timestamps %v, want %v", timestamps, want)
+	}
+	_, err = dbs.AppendPrices(trade.ID, nil)
+	wantCode(t, err, model.CodeInvalidPayload)
+
+	// A tick of a symbol is recorded once, by whichever write brings it
+	// first; later ones with the same timestamp are ignored, not failed.
+	if _, err := dbs.AppendPrices(trade.ID, []model.PricePoint{{Timestamp: 90, Price: 9}, {Timestamp: 90, Price: 9.5}}); err != nil {
+		t.Fatalf("AppendPrices with a duplicate tick: %v", err)
+	}
+	other := createTradingSystem(t, dbs, &model.TradingSystemData{Symbol: "BTC"})
+	if _, err := dbs.AppendPrices(other.ID, []model.PricePoint{{Timestamp: 90, Price: 1}, {Timestamp: 100, Price: 10}}); err != nil {
+		t.Fatalf("AppendPrices of a tick another trading system recorded: %v", err)
+	}
+	ticks, err = dbs.ReadPrices(model.PriceQuery{Symbol: "BTC", From: 90})
+	if err != nil {
+		t.Fatalf("ReadPrices: %v", err)
+	}
+	if len(ticks) != 2 || ticks[0].Price != 9 || ticks[1].Timestamp != 100 {
+		t.Fatalf("read ticks %+v, want the first tick at 90 and the one at 100", ticks)
+	}
+}
+
+func testDelete(t *testing.T, dbs model.DBServicer) {
+	trade := createTradingSystem(t, dbs, &model.TradingSystemData{Symbol: "BTC"})
+	if err := dbs.DeleteTradingSystem(trade.ID); err != nil {
+		t.Fatalf("DeleteTradingSystem: %v", err)
+	}
+	_, err := dbs.ReadTradingSystem(trade.ID)
+	wantCode(t, err, model.CodeNotFound)
+	wantCode(t, dbs.DeleteTradingSystem(trade.ID), model.CodeNotFound)
+	wantCode(t, dbs.DeleteTradingSystem(0), model.CodeInvalidPayload)
+}
+
+func testHistoryRevert(t *testing.T, dbs model.DBServicer) {
+	dbs = dbs.WithClient("tester")
+	trade := createTradingSystem(t, dbs, &model.TradingSystemData{Symbol: "BTC", QuoteBalance: 100})
+	for _, patch := range []string{`{"quote_balance": 90}`, `{"quote_balance": -1}`} {
+		if _, err := dbs.PatchTradingSystem(trade.ID, []byte(patch)); err != nil {
+			t.Fatalf("PatchTradingSystem: %v", err)
+		}
+	}
+
+	entries, next, err := dbs.ListHistory(model.HistoryQuery{ID: trade.ID, Limit: 2})
+	if err != nil {
+		t.Fatalf("ListHistory: %v", err)
+	}
+	if len(entries) != 2 || next == "" {
+		t.Fatalf("first history page has %d entries and cursor %q, want 2 and a cursor", len(entries), next)
+	}
+	rest, next, err := dbs.ListHistory(model.HistoryQuery{ID: trade.ID, Cursor: next})
+	if err != nil {
+		t.Fatalf("ListHistory: %v", err)
+	}
+	entries = append(entries, rest...)
+	var actions []string
+	for i, e := range entries {
+		if e.Revision != uint(i+1) || e.Client != "tester" {
+			t.Fatalf("history entry %d is revision %d by %q, want revision %d by tester", i, e.Revision, e.Client, i+1)
+		}
+		actions = append(actions, e.Action)
+	}
+	if want := []string{model.HistoryCreate, model.HistoryPatch, model.HistoryPatch}; !reflect.DeepEqual(actions, want) || next != "" {
+		t.Fatalf("history actions %v and cursor %q, want %v and no cursor", actions, next, want)
+	}
+
+	old, err := dbs.ReadTradingSystemRevision(trade.ID, 2)
+	if err != nil {
+		t.Fatalf("ReadTradingSystemRevision: %v", err)
+	}
+	if old.QuoteBalance != 90 || old.Revision != 2 {
+		t.Fatalf("revision 2 has balance %v, want 90", old.QuoteBalance)
+	}
+	_, err = dbs.ReadTradingSystemRevision(trade.ID, 9)
+	wantCode(t, err, model.CodeNotFound)
+
+	current, err := dbs.ReadTradingSystemAsOf(trade.ID, time.Now())
+	if err != nil || current.Revision != 3 {
+		t.Fatalf("ReadTradingSystemAsOf(now) = %v, %v, want revision 3", current, err)
+	}
+	_, err = dbs.ReadTradingSystemAsOf(trade.ID, time.Now().Add(-time.Hour))
+	wantCode(t, err, model.CodeNotFound)
+	// The instant counts, not the zone it is given in.
+	for _, offset := range []int{-5, 5} {
+		asOf := time.Now().Add(time.Minute).In(time.FixedZone("", offset*3600))
+		if current, err := dbs.ReadTradingSystemAsOf(trade.ID, asOf); err != nil || current.Revision != 3 {
+			t.Fatalf("ReadTradingSystemAsOf(%s) = %v, %v, want revision 3", asOf, current, err)
+		}
+	}
+
+	reverted, err := dbs.RevertTradingSystem(trade.ID, 1)
+	if err != nil {
+		t.Fatalf("RevertTradingSystem: %v", err)
+	}
+	if reverted.QuoteBalance != 100 || reverted.Revision != 4 {
+		t.Fatalf("reverted to balance %v at revision %d, want 100 at revision 4", reverted.QuoteBalance, reverted.Revision)
+	}
+
+	if err := dbs.DeleteTradingSystem(trade.ID); err != nil {
+		t.Fatalf("DeleteTradingSystem: %v", err)
+	}
+	entries, _, err = dbs.ListHistory(model.HistoryQuery{ID: trade.ID, Cursor: "4"})
+	if err != nil || len(entries) != 1 || entries[0].Action != model.HistoryDelete {
+		t.Fatalf("history after delete = %v, %v, want one delete entry", entries, err)
+	}
+}
+
+func testAPIKeys(t *testing.T, dbs model.DBServicer) {
+	keys, ok := dbs.(model.APIKeyServicer)
+	if !ok {
+		t.Skip("backend does not store API keys")
+	}
+	key, token, err := model.NewAPIKey("bot", []string{model.ScopeRead, model.ScopeWrite})
+	if err != nil {
+		t.Fatalf("NewAPIKey: %v", err)
+	}
+	if err := keys.CreateAPIKey(key); err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	if key.ID == 0 || key.CreatedAt.IsZero() {
+		t.Fatalf("CreateAPIKey did not set the ID and creation time: %+v", key)
+	}
+	dup, _ := model.NewAPIKeyFromToken("again", token, []string{model.ScopeAdmin})
+	wantCode(t, keys.CreateAPIKey(dup), model.CodeConflict)
+
+	found, err := keys.FindAPIKey(model.HashAPIKey(token))
+	if err != nil {
+		t.Fatalf("FindAPIKey: %v", err)
+	}
+	if found.ID != key.ID || found.Name != "bot" || !reflect.DeepEqual([]string(found.Scopes), []string{model.ScopeRead, model.ScopeWrite}) || found.Revoked() {
+		t.Fatalf("FindAPIKey returned %+v", found)
+	}
+	_, err = keys.FindAPIKey(model.HashAPIKey(token + "x"))
+	wantCode(t, err, model.CodeNotFound)
+
+	admin, _, _ := model.NewAPIKey("admin", []string{model.ScopeAdmin})
+	if err := keys.CreateAPIKey(admin); err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	revoked, err := keys.RevokeAPIKey(key.ID)
+	if err != nil || !revoked.Revoked() {
+		t.Fatalf("RevokeAPIKey returned %+v, %v", revoked, err)
+	}
+	if _, err := keys.RevokeAPIKey(key.ID); err != nil {
+		t.Fatalf("RevokeAPIKey again: %v", err)
+	}
+	_, err = keys.RevokeAPIKey(admin.ID + 1)
+	wantCode(t, err, model.CodeNotFound)
+
+	list, err := keys.ListAPIKeys()
+	if err != nil {
+		t.Fatalf("ListAPIKeys: %v", err)
+	}
+	if len(list) != 2 || list[0].ID != key.ID || !list[0].Revoked() || list[1].ID != admin.ID || list[1].Revoked() {
+		t.Fatalf("ListAPIKeys returned %+v", list)
+	}
+}
@@ -0,0 +1,145 @@
+package dbtest
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	dbgorm "github.com/chidi150c/database/gorm"
+)
+
+// PostgresDSN creates an empty database on a throwaway PostgreSQL server and
+// returns its connection string. The server is started on first use from the
+// binaries found in PG_BIN, PATH or the usual install directories, and only
+// listens on a Unix socket in its temporary directory. Tests are skipped when
+// it cannot start, unless the CI environment variable is set: there a missing
+// server fails them. Call StopPostgres from TestMain.
+func PostgresDSN(t *testing.T) string {
+	t.Helper()
+	if err := postgres.start(); err != nil {
+		if os.Getenv("CI") != "" {
+			t.Fatalf("PostgreSQL not available: %v", err)
+		}
+		t.Skipf("PostgreSQL not available: %v", err)
+	}
+	return postgres.newDatabase(t)
+}
+
+// StopPostgres stops the server started by PostgresDSN, if any.
+func StopPostgres() {
+	postgres.stop()
+}
+
+var postgres testPostgres
+
+type testPostgres struct {
+	once sync.Once
+	err  error
+	bin  string
+	dir  string
+	port int
+	dbs  int32
+	// setUser, when set, has a command run as the user owning dir.
+	setUser func(cmd *exec.Cmd)
+}
+
+func (p *testPostgres) start() error {
+	p.once.Do(func() { p.err = p.init() })
+	return p.err
+}
+
+func (p *testPostgres) init() error {
+	initdb, err := findPostgresBinary("initdb")
+	if err != nil {
+		return err
+	}
+	p.bin = filepath.Dir(initdb)
+	if p.dir, err = os.MkdirTemp("", "pgtest"); err != nil {
+		return err
+	}
+	if p.port, err = freePort(); err != nil {
+		return err
+	}
+	// initdb and postgres refuse to run as root
+	if os.Geteuid() == 0 {
+		if p.setUser, err = unprivilegedUser(p.dir); err != nil {
+			return err
+		}
+	}
+	data := filepath.Join(p.dir, "data")
+	if out, err := p.command(initdb, "-D", data, "-U", "postgres", "-A", "trust", "-E", "UTF8", "--no-sync").CombinedOutput(); err != nil {
+		return fmt.Errorf("initdb: %v: %s", err, out)
+	}
+	opts := fmt.Sprintf("-F -p %d -k %s -c listen_addresses=''", p.port, p.dir)
+	pgctl := filepath.Join(p.bin, "pg_ctl")
+	if out, err := p.command(pgctl, "-D", data, "-l", filepath.Join(p.dir, "log"), "-o", opts, "-w", "start").CombinedOutput(); err != nil {
+		return fmt.Errorf("pg_ctl start: %v: %s", err, out)
+	}
+	return nil
+}
+
+// command returns the command running a PostgreSQL binary.
+func (p *testPostgres) command(name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(name, args...)
+	if p.setUser != nil {
+		p.setUser(cmd)
+	}
+	return cmd
+}
+
+func (p *testPostgres) stop() {
+	if p.dir == "" {
+		return
+	}
+	p.command(filepath.Join(p.bin, "pg_ctl"), "-D", filepath.Join(p.dir, "data"), "-m", "immediate", "stop").Run()
+	os.RemoveAll(p.dir)
+}
+
+// newDatabase creates an empty database and returns its connection string.
+func (p *testPostgres) newDatabase(t *testing.T) string {
+	t.Helper()
+	admin, err := dbgorm.Open(dbgorm.DriverPostgres, p.dsn("postgres"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer admin.Close()
+	name := fmt.Sprintf("test_%d", atomic.AddInt32(&p.dbs, 1))
+	if err := admin.DB.Exec("CREATE DATABASE " + name).Error; err != nil {
+		t.Fatalf("CREATE DATABASE: %v", err)
+	}
+	return p.dsn(name)
+}
+
+func (p *testPostgres) dsn(dbName string) string {
+	return fmt.Sprintf("host=%s port=%d user=postgres dbname=%s sslmode=disable", p.dir, p.port, dbName)
+}
+
+func findPostgresBinary(name string) (string, error) {
+	if dir := os.Getenv("PG_BIN"); dir != "" {
+		return exec.LookPath(filepath.Join(dir, name))
+	}
+	if path, err := exec.LookPath(name); err == nil {
+		return path, nil
+	}
+	for _, pattern := range []string{"/usr/lib/postgresql/*/bin", "/usr/local/pgsql/bin", "/opt/homebrew/bin", "/usr/local/opt/postgresql*/bin"} {
+		matches, _ := filepath.Glob(filepath.Join(pattern, name))
+		if len(matches) > 0 {
+			return matches[len(matches)-1], nil
+		}
+	}
+	return "", fmt.Errorf("%s not found, set PG_BIN to the PostgreSQL bin directory", name)
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package dbtest
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// unprivilegedUser is only needed where there is a root user.
+func unprivilegedUser(dir string) (func(cmd *exec.Cmd), error) {
+	return nil, fmt.Errorf("cannot run PostgreSQL as another user on this system")
+}
@@ -0,0 +1,71 @@
+package gorm
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/chidi150c/database/model"
+	"github.com/jinzhu/gorm"
+)
+
+var _ model.APIKeyServicer = &DBServices{}
+
+func (s *DBServices) CreateAPIKey(key *model.APIKey) error {
+	return s.transaction(func(tx *gorm.DB) error {
+		var count int
+		if err := tx.Model(&model.APIKey{}).Where("hash = ?", key.Hash).Count(&count).Error; err != nil {
+			return &model.Error{Code: model.CodeInternal, Message: "Error checking API key", Err: err}
+		}
+		if count > 0 {
+			return model.Errorf(model.CodeConflict, "An API key with this token already exists")
+		}
+		if err := tx.Create(key).Error; err != nil {
+			return &model.Error{Code: model.CodeInternal, Message: "Error creating API key", Err: err}
+		}
+		return nil
+	})
+}
+
+func (s *DBServices) FindAPIKey(hash string) (*model.APIKey, error) {
+	key := new(model.APIKey)
+	if err := s.DB.Where("hash = ?", hash).First(key).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, model.Errorf(model.CodeNotFound, "API key not found")
+		}
+		return nil, &model.Error{Code: model.CodeInternal, Message: "Error fetching API key", Err: err}
+	}
+	return key, nil
+}
+
+func (s *DBServices) ListAPIKeys() ([]*model.APIKey, error) {
+	var keys []*model.APIKey
+	if err := s.DB.Order("id").Find(&keys).Error; err != nil {
+		return nil, &model.Error{Code: model.CodeInternal, Message: "Error listing API keys", Err: err}
+	}
+	return keys, nil
+}
+
+func (s *DBServices) RevokeAPIKey(id uint) (*model.APIKey, error) {
+	key := new(model.APIKey)
+	err := s.transaction(func(tx *gorm.DB) error {
+		if err := tx.First(key, id).Error; err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				return model.Errorf(model.CodeNotFound, "API key with ID %d not found", id)
+			}
+			return &model.Error{Code: model.CodeInternal, Message: fmt.Sprintf("Error fetching API key with ID %d", id), Err: err}
+		}
+		if key.Revoked() {
+			return nil
+		}
+		now := time.Now()
+		if err := tx.Model(key).UpdateColumn("revoked_at", now).Error; err != nil {
+			return &model.Error{Code: model.CodeInternal, Message: fmt.Sprintf("Error revoking API key with ID %d", id), Err: err}
+		}
+		key.RevokedAt = &now
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
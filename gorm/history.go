@@ -0,0 +1,182 @@
+package gorm
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/chidi150c/database/model"
+	"github.com/jinzhu/gorm"
+)
+
+// WithClient returns a DBServices sharing the database of a that records
+// client as the origin of its writes in the history.
+func (a *DBServices) WithClient(client string) model.DBServicer {
+	return &DBServices{DB: a.DB, client: client}
+}
+
+// recordHistory appends the history entry of the revision trade was just
+// brought to. It runs in the transaction of the write itself, so a write and
+// its history entry are stored together or not at all.
+func (s *DBServices) recordHistory(tx *gorm.DB, action string, trade *model.TradingSystem, changes []model.FieldChange) error {
+	entry := model.NewHistory(action, s.client, trade, changes)
+	if err := tx.Create(entry).Error; err != nil {
+		return &model.Error{Code: model.CodeInternal, Message: fmt.Sprintf("Error recording history of TradingSystem with ID %d", trade.ID), Err: err}
+	}
+	return nil
+}
+
+// ListHistory returns one page of the history of a trading system in
+// ascending revision order and the cursor of the next page, which is empty on
+// the last page. The history of deleted trading systems stays available.
+func (s *DBServices) ListHistory(query model.HistoryQuery) ([]*model.TradingSystemHistory, string, error) {
+	if err := query.Normalize(); err != nil {
+		return nil, "", model.Errorf(model.CodeInvalidPayload, "Invalid history query: %v", err)
+	}
+	after, _ := query.AfterRevision()
+	var entries []*model.TradingSystemHistory
+	err := s.DB.Where("trading_system_id = ? AND revision > ?", query.ID, after).
+		Order("revision ASC").
+		Limit(query.Limit + 1).
+		Find(&entries).Error
+	if err != nil {
+		return nil, "", &model.Error{Code: model.CodeInternal, Message: fmt.Sprintf("Error fetching history of TradingSystem with ID %d", query.ID), Err: err}
+	}
+	if len(entries) == 0 && after == 0 {
+		// Tell an unknown ID apart from a trading system without history
+		if err := s.DB.Unscoped().First(&model.TradingSystem{}, query.ID).Error; err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				return nil, "", model.Errorf(model.CodeNotFound, "TradingSystem with ID %d not found", query.ID)
+			}
+			return nil, "", &model.Error{Code: model.CodeInternal, Message: fmt.Sprintf("Error fetching TradingSystem with ID %d", query.ID), Err: err}
+		}
+	}
+	next := ""
+	if len(entries) > query.Limit {
+		entries = entries[:query.Limit]
+		next = model.HistoryCursor(entries[len(entries)-1])
+	}
+	return entries, next, nil
+}
+
+// ReadTradingSystemRevision returns the trading system as it was at revision,
+// rebuilt from its current state and history. Deleted trading systems can be
+// read at any revision before their deletion.
+func (s *DBServices) ReadTradingSystemRevision(tradeID uint, revision uint) (*model.TradingSystem, error) {
+	if tradeID == 0 || revision == 0 {
+		return nil, model.Errorf(model.CodeInvalidPayload, "TradingSystem ID and revision are required")
+	}
+	trade := new(model.TradingSystem)
+	if err := s.DB.Unscoped().First(trade, tradeID).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, model.Errorf(model.CodeNotFound, "TradingSystem with ID %d not found", tradeID)
+		}
+		return nil, &model.Error{Code: model.CodeInternal, Message: fmt.Sprintf("Error fetching TradingSystem with ID %d", tradeID), Err: err}
+	}
+	return rewindTradingSystem(s.DB, trade, revision)
+}
+
+// ReadTradingSystemAsOf returns the trading system as it was at asOf, that is
+// at the last revision written no later than asOf.
+func (s *DBServices) ReadTradingSystemAsOf(tradeID uint, asOf time.Time) (*model.TradingSystem, error) {
+	if tradeID == 0 {
+		return nil, model.Errorf(model.CodeInvalidPayload, "TradingSystem ID is required")
+	}
+	trade := new(model.TradingSystem)
+	if err := s.DB.Unscoped().First(trade, tradeID).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, model.Errorf(model.CodeNotFound, "TradingSystem with ID %d not found", tradeID)
+		}
+		return nil, &model.Error{Code: model.CodeInternal, Message: fmt.Sprintf("Error fetching TradingSystem with ID %d", tradeID), Err: err}
+	}
+	if trade.CreatedAt.After(asOf) {
+		return nil, model.Errorf(model.CodeNotFound, "TradingSystem with ID %d did not exist at %s", tradeID, asOf.Format(time.RFC3339))
+	}
+	var entries []*model.TradingSystemHistory
+	err := s.DB.Where("trading_system_id = ? AND created_at <= ?", tradeID, StorageTime(asOf)).
+		Order("revision DESC").
+		Limit(1).
+		Find(&entries).Error
+	if err != nil {
+		return nil, &model.Error{Code: model.CodeInternal, Message: fmt.Sprintf("Error fetching history of TradingSystem with ID %d", tradeID), Err: err}
+	}
+	revision := trade.Revision
+	if len(entries) > 0 {
+		revision = entries[0].Revision
+	} else {
+		// Nothing recorded yet at asOf: the trading system was still at the
+		// revision before its first history entry
+		err := s.DB.Where("trading_system_id = ?", tradeID).Order("revision ASC").Limit(1).Find(&entries).Error
+		if err != nil {
+			return nil, &model.Error{Code: model.CodeInternal, Message: fmt.Sprintf("Error fetching history of TradingSystem with ID %d", tradeID), Err: err}
+		}
+		if len(entries) > 0 {
+			revision = entries[0].Revision - 1
+		}
+	}
+	if revision == 0 {
+		return nil, model.Errorf(model.CodeNotFound, "TradingSystem with ID %d did not exist at %s", tradeID, asOf.Format(time.RFC3339))
+	}
+	return rewindTradingSystem(s.DB, trade, revision)
+}
+
+// RevertTradingSystem writes the state the trading system had at revision
+// back as a new revision. The history is kept, so a revert can be reverted.
+func (s *DBServices) RevertTradingSystem(tradeID uint, revision uint) (*model.TradingSystem, error) {
+	if tradeID == 0 || revision == 0 {
+		return nil, model.Errorf(model.CodeInvalidPayload, "TradingSystem ID and revision are required for revert")
+	}
+	var trade *model.TradingSystem
+	err := s.transaction(func(tx *gorm.DB) error {
+		existing, err := firstTradingSystem(tx, tradeID)
+		if err != nil {
+			return err
+		}
+		target, err := rewindTradingSystem(tx, existing, revision)
+		if err != nil {
+			return err
+		}
+		if err := s.updateColumns(tx, model.HistoryRevert, existing, model.Diff(existing, target)); err != nil {
+			return err
+		}
+		trade = existing
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return trade, nil
+}
+
+// rewindTradingSystem returns trade as it was at revision by undoing the
+// history entries written after it.
+func rewindTradingSystem(db *gorm.DB, trade *model.TradingSystem, revision uint) (*model.TradingSystem, error) {
+	if revision > trade.Revision {
+		return nil, model.Errorf(model.CodeNotFound, "TradingSystem with ID %d has no revision %d", trade.ID, revision)
+	}
+	var entries []*model.TradingSystemHistory
+	err := db.Where("trading_system_id = ? AND revision >= ?", trade.ID, revision).
+		Order("revision ASC").
+		Find(&entries).Error
+	if err != nil {
+		return nil, &model.Error{Code: model.CodeInternal, Message: fmt.Sprintf("Error fetching history of TradingSystem with ID %d", trade.ID), Err: err}
+	}
+	// The entry of revision itself tells whether it was a deletion
+	deleted := revision == trade.Revision && trade.DeletedAt != nil
+	if len(entries) > 0 && entries[0].Revision == revision {
+		deleted = entries[0].Action == model.HistoryDelete
+		entries = entries[1:]
+	}
+	if deleted {
+		return nil, model.Errorf(model.CodeNotFound, "TradingSystem with ID %d was deleted at revision %d", trade.ID, revision)
+	}
+	// Every revision in between must be known to rebuild the state
+	if uint(len(entries)) != trade.Revision-revision {
+		return nil, model.Errorf(model.CodeNotFound, "History of TradingSystem with ID %d does not go back to revision %d", trade.ID, revision)
+	}
+	rewound, err := trade.Rewind(entries)
+	if err != nil {
+		return nil, &model.Error{Code: model.CodeInternal, Message: fmt.Sprintf("Error rebuilding revision %d of TradingSystem with ID %d", revision, trade.ID), Err: err}
+	}
+	rewound.DeletedAt = nil
+	return rewound, nil
+}
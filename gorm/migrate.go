@@ -0,0 +1,213 @@
+package gorm
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Migration is one numbered step of the database schema. Up moves the schema
+// from Version-1 to Version and Down back again. Both run in a transaction
+// together with the bookkeeping in schema_versions. Steps describe their
+// tables with the copies in schema.go. Up steps built on AutoMigrate are
+// idempotent, so databases created before migrations existed are brought up
+// to date by simply running every step.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(tx *gorm.DB) error
+	Down        func(tx *gorm.DB) error
+}
+
+// SchemaVersion records a migration applied to the database.
+type SchemaVersion struct {
+	Version     int `gorm:"primary_key;auto_increment:false"`
+	Description string
+	AppliedAt   time.Time
+}
+
+// migrations lists every migration in version order. Append new migrations
+// at the end and never change or renumber one that has been released.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "create trading_systems",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&tradingSystemV1{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.DropTableIfExists(&tradingSystemV1{}).Error
+		},
+	},
+	{
+		Version:     2,
+		Description: "add trading_systems.revision",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&tradingSystemV2{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Model(&tradingSystemV2{}).DropColumn("revision").Error
+		},
+	},
+	{
+		Version:     3,
+		Description: "create price_ticks from the trading system price history",
+		Up: func(tx *gorm.DB) error {
+			exists := tableExists(tx, "price_ticks")
+			if err := tx.AutoMigrate(&priceTickV3{}).Error; err != nil {
+				return err
+			}
+			if exists {
+				return nil
+			}
+			return backfillPriceTicks(tx)
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.DropTableIfExists(&priceTickV3{}).Error
+		},
+	},
+	{
+		Version:     4,
+		Description: "create trading_system_histories",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&tradingSystemHistoryV4{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.DropTableIfExists(&tradingSystemHistoryV4{}).Error
+		},
+	},
+	{
+		Version:     5,
+		Description: "store JSON columns as jsonb on PostgreSQL",
+		Up: func(tx *gorm.DB) error {
+			return alterJSONColumns(tx, "jsonb", &tradingSystemV2{}, &tradingSystemHistoryV4{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return alterJSONColumns(tx, "json", &tradingSystemV2{}, &tradingSystemHistoryV4{})
+		},
+	},
+	{
+		Version:     6,
+		Description: "create api_keys",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&apiKeyV6{}).Error; err != nil {
+				return err
+			}
+			return alterJSONColumns(tx, "jsonb", &apiKeyV6{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.DropTableIfExists(&apiKeyV6{}).Error
+		},
+	},
+}
+
+// alterJSONColumns changes the type of every JSON column of the given models
+// to typ on PostgreSQL. SQLite has a single JSON representation, so it is
+// left alone.
+func alterJSONColumns(tx *gorm.DB, typ string, values ...interface{}) error {
+	if tx.Dialect().GetName() != DriverPostgres {
+		return nil
+	}
+	for _, value := range values {
+		scope := tx.NewScope(value)
+		for _, field := range scope.GetModelStruct().StructFields {
+			if !strings.EqualFold(field.TagSettings["TYPE"], "json") {
+				continue
+			}
+			sql := fmt.Sprintf("ALTER TABLE %[1]s ALTER COLUMN %[2]s TYPE %[3]s USING %[2]s::%[3]s",
+				scope.QuotedTableName(), scope.Quote(field.DBName), typ)
+			if err := tx.Exec(sql).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// LatestSchemaVersion is the schema version this binary expects.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// SchemaVersion returns the version of the database schema, 0 for a database
+// no migration ran against.
+func (a *DBServices) SchemaVersion() (int, error) {
+	if err := a.DB.AutoMigrate(&SchemaVersion{}).Error; err != nil {
+		return 0, fmt.Errorf("Error migrating SchemaVersion table: %v", err)
+	}
+	var current struct{ Version int }
+	if err := a.DB.Model(&SchemaVersion{}).Select("COALESCE(MAX(version), 0) AS version").Scan(&current).Error; err != nil {
+		return 0, fmt.Errorf("Error reading schema version: %v", err)
+	}
+	return current.Version, nil
+}
+
+// Migrate brings the database schema up to the latest version. It refuses to
+// run against a schema newer than this binary knows, since the binary would
+// then read and write tables it does not understand.
+func (a *DBServices) Migrate() error {
+	return a.MigrateTo(LatestSchemaVersion())
+}
+
+// MigrateTo runs the up or down steps needed to bring the database schema to
+// version, one transaction per step.
+func (a *DBServices) MigrateTo(version int) error {
+	latest := LatestSchemaVersion()
+	if version < 0 || version > latest {
+		return fmt.Errorf("unknown schema version %d, this binary knows versions 0 to %d", version, latest)
+	}
+	current, err := a.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if current > latest {
+		return fmt.Errorf("database schema version %d is newer than version %d supported by this binary", current, latest)
+	}
+	for _, m := range migrations {
+		if m.Version > current && m.Version <= version {
+			if err := a.runMigration(m, true); err != nil {
+				return err
+			}
+		}
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		if m := migrations[i]; m.Version <= current && m.Version > version {
+			if err := a.runMigration(m, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// runMigration applies the up or down step of m and records the outcome.
+func (a *DBServices) runMigration(m Migration, up bool) error {
+	tx := a.DB.Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("Error starting migration %d: %v", m.Version, tx.Error)
+	}
+	step := m.Down
+	if up {
+		step = m.Up
+	}
+	if err := step(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Error running migration %d (%s): %v", m.Version, m.Description, err)
+	}
+	var err error
+	if up {
+		err = tx.Create(&SchemaVersion{Version: m.Version, Description: m.Description, AppliedAt: time.Now()}).Error
+	} else {
+		err = tx.Where("version = ?", m.Version).Delete(&SchemaVersion{}).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Error recording migration %d: %v", m.Version, err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("Error committing migration %d: %v", m.Version, err)
+	}
+	return nil
+}
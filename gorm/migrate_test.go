@@ -0,0 +1,200 @@
+package gorm_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chidi150c/database/dbtest"
+	dbgorm "github.com/chidi150c/database/gorm"
+	"github.com/chidi150c/database/model"
+)
+
+// forEachDriver runs test against an empty SQLite database and an empty
+// PostgreSQL one.
+func forEachDriver(t *testing.T, test func(t *testing.T, driver, dsn string)) {
+	t.Run("SQLite", func(t *testing.T) {
+		test(t, dbgorm.DriverSQLite, filepath.Join(t.TempDir(), "test.db"))
+	})
+	t.Run("Postgres", func(t *testing.T) {
+		test(t, dbgorm.DriverPostgres, dbtest.PostgresDSN(t))
+	})
+}
+
+// columnType returns the type PostgreSQL reports for column.
+func columnType(t *testing.T, dbs *dbgorm.DBServices, table, column string) string {
+	t.Helper()
+	var col struct{ DataType string }
+	err := dbs.DB.Table("information_schema.columns").
+		Select("data_type").
+		Where("table_name = ? AND column_name = ?", table, column).
+		Scan(&col).Error
+	if err != nil {
+		t.Fatalf("type of %s.%s: %v", table, column, err)
+	}
+	return col.DataType
+}
+
+func TestMigrateUpDownUp(t *testing.T) {
+	forEachDriver(t, testMigrateUpDownUp)
+}
+
+func testMigrateUpDownUp(t *testing.T, driver, dsn string) {
+	dbs := openTestDB(t, driver, dsn)
+	latest := dbgorm.LatestSchemaVersion()
+	tables := []string{"trading_systems", "price_ticks", "trading_system_histories", "api_keys"}
+	checkVersion := func(want int) {
+		t.Helper()
+		if got, err := dbs.SchemaVersion(); err != nil || got != want {
+			t.Fatalf("SchemaVersion() = %d, %v, want %d", got, err, want)
+		}
+	}
+	// The JSON columns are jsonb on PostgreSQL from version 5
+	checkJSONType := func(want string) {
+		t.Helper()
+		if driver != dbgorm.DriverPostgres {
+			return
+		}
+		for _, c := range [][2]string{{"trading_systems", "closing_prices"}, {"trading_systems", "stop_loss_recover"}, {"trading_system_histories", "changes"}} {
+			if got := columnType(t, dbs, c[0], c[1]); got != want {
+				t.Fatalf("%s.%s is %s, want %s", c[0], c[1], got, want)
+			}
+		}
+	}
+	checkVersion(latest)
+	checkJSONType("jsonb")
+	id, err := dbs.CreateTradingSystem(&model.TradingSystem{Symbol: "BTCUSDT"})
+	if err != nil {
+		t.Fatalf("CreateTradingSystem: %v", err)
+	}
+
+	if err := dbs.MigrateTo(4); err != nil {
+		t.Fatalf("MigrateTo(4): %v", err)
+	}
+	checkVersion(4)
+	checkJSONType("json")
+	if err := dbs.MigrateTo(1); err != nil {
+		t.Fatalf("MigrateTo(1): %v", err)
+	}
+	checkVersion(1)
+	if dbs.DB.Dialect().HasColumn("trading_systems", "revision") {
+		t.Fatal("trading_systems.revision still exists at version 1")
+	}
+	var symbol struct{ Symbol string }
+	if err := dbs.DB.Table("trading_systems").Select("symbol").Where("id = ?", id).Scan(&symbol).Error; err != nil || symbol.Symbol != "BTCUSDT" {
+		t.Fatalf("trading system at version 1 is %+v, %v", symbol, err)
+	}
+
+	if err := dbs.MigrateTo(0); err != nil {
+		t.Fatalf("MigrateTo(0): %v", err)
+	}
+	checkVersion(0)
+	for _, table := range tables {
+		if dbs.DB.HasTable(table) {
+			t.Fatalf("table %s still exists at version 0", table)
+		}
+	}
+
+	if err := dbs.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	checkVersion(latest)
+	checkJSONType("jsonb")
+	for _, table := range tables {
+		if !dbs.DB.HasTable(table) {
+			t.Fatalf("table %s is missing after migrating up again", table)
+		}
+	}
+	id, err = dbs.CreateTradingSystem(&model.TradingSystem{Symbol: "ETHUSDT"})
+	if err != nil {
+		t.Fatalf("CreateTradingSystem after migrating up again: %v", err)
+	}
+	if _, err := dbs.AppendPrices(id, []model.PricePoint{{Timestamp: 1, Price: 10}}); err != nil {
+		t.Fatalf("AppendPrices after migrating up again: %v", err)
+	}
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	forEachDriver(t, testMigrateRefusesNewerSchema)
+}
+
+func testMigrateRefusesNewerSchema(t *testing.T, driver, dsn string) {
+	dbs := openTestDB(t, driver, dsn)
+	newer := dbgorm.LatestSchemaVersion() + 1
+	if err := dbs.DB.Create(&dbgorm.SchemaVersion{Version: newer, Description: "from a newer binary", AppliedAt: time.Now()}).Error; err != nil {
+		t.Fatal(err)
+	}
+	for name, migrate := range map[string]func() error{
+		"Migrate":   dbs.Migrate,
+		"MigrateTo": func() error { return dbs.MigrateTo(1) },
+	} {
+		if err := migrate(); err == nil || !strings.Contains(err.Error(), "newer") {
+			t.Fatalf("%s against schema version %d returned %v, want a refusal", name, newer, err)
+		}
+	}
+	// Nothing was rolled back.
+	if !dbs.DB.Dialect().HasColumn("trading_systems", "revision") {
+		t.Fatal("trading_systems.revision was dropped")
+	}
+	if got, err := dbs.SchemaVersion(); err != nil || got != newer {
+		t.Fatalf("SchemaVersion() = %d, %v, want %d", got, err, newer)
+	}
+}
+
+func TestMigrateBackfillsPriceTicks(t *testing.T) {
+	forEachDriver(t, testMigrateBackfillsPriceTicks)
+}
+
+func testMigrateBackfillsPriceTicks(t *testing.T, driver, dsn string) {
+	dbs, err := dbgorm.Open(driver, dsn)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { dbs.Close() })
+	if err := dbs.MigrateTo(2); err != nil {
+		t.Fatalf("MigrateTo(2): %v", err)
+	}
+	// Trading systems as version 2 stores them, with their price history in
+	// the JSON columns only.
+	now := time.Now()
+	for _, row := range []struct {
+		symbol, prices, timestamps, signals string
+		deletedAt                           *time.Time
+	}{
+		{"BTC", "[1,2,3]", "[10,20,30]", `["buy","hold"]`, nil},
+		{"BTC", "[3.5,4]", "[30,40]", `["dup","sell"]`, nil},
+		{"ETH", "[7]", "[70,80]", "null", &now},
+		{"DOGE", "null", "null", "null", nil},
+	} {
+		err := dbs.DB.Exec("INSERT INTO trading_systems (symbol, closing_prices, timestamps, signals, revision, created_at, updated_at, deleted_at) VALUES (?, ?, ?, ?, 1, ?, ?, ?)",
+			row.symbol, row.prices, row.timestamps, row.signals, now, now, row.deletedAt).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := dbs.MigrateTo(3); err != nil {
+		t.Fatalf("MigrateTo(3): %v", err)
+	}
+	var ticks []model.PriceTick
+	if err := dbs.DB.Order("symbol ASC, timestamp ASC").Find(&ticks).Error; err != nil {
+		t.Fatal(err)
+	}
+	for i := range ticks {
+		ticks[i].ID = 0
+	}
+	// A timestamp already recorded for the symbol keeps its first tick, and
+	// soft-deleted trading systems count too.
+	want := []model.PriceTick{
+		{Symbol: "BTC", Timestamp: 10, Price: 1, Signal: "buy"},
+		{Symbol: "BTC", Timestamp: 20, Price: 2, Signal: "hold"},
+		{Symbol: "BTC", Timestamp: 30, Price: 3},
+		{Symbol: "BTC", Timestamp: 40, Price: 4, Signal: "sell"},
+		{Symbol: "ETH", Timestamp: 70, Price: 7},
+	}
+	if !reflect.DeepEqual(ticks, want) {
+		t.Fatalf("price_ticks holds %+v, want %+v", ticks, want)
+	}
+}
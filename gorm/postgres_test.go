@@ -0,0 +1,22 @@
+package gorm_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/chidi150c/database/dbtest"
+	dbgorm "github.com/chidi150c/database/gorm"
+	"github.com/chidi150c/database/model"
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	dbtest.StopPostgres()
+	os.Exit(code)
+}
+
+func TestPostgresConformance(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) model.DBServicer {
+		return openTestDB(t, dbgorm.DriverPostgres, dbtest.PostgresDSN(t))
+	})
+}
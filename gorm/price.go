@@ -0,0 +1,170 @@
+package gorm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/chidi150c/database/model"
+	"github.com/jinzhu/gorm"
+)
+
+// SyncPriceTicks records the ticks of trade that are newer than the last tick
+// stored for its symbol. Ticks another trading system of the same symbol
+// already recorded are skipped. It runs in the transaction tx of the write
+// of trade.
+func SyncPriceTicks(tx *gorm.DB, trade *model.TradingSystem) error {
+	ticks := trade.PriceTicks()
+	if len(ticks) == 0 || trade.Symbol == "" {
+		return nil
+	}
+	var last struct{ Timestamp *int64 }
+	err := tx.Model(&model.PriceTick{}).
+		Select("MAX(timestamp) AS timestamp").
+		Where("symbol = ?", trade.Symbol).
+		Scan(&last).Error
+	if err != nil {
+		return &model.Error{Code: model.CodeInternal, Message: "Error reading price history", Err: err}
+	}
+	for i := range ticks {
+		if last.Timestamp != nil && ticks[i].Timestamp <= *last.Timestamp {
+			continue
+		}
+		if err := insertPriceTick(tx, &ticks[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertPriceTick stores tick unless its symbol and timestamp already exist.
+// The statement is written out because GORM adds RETURNING "id" to inserts
+// on PostgreSQL, and a conflicting row returns nothing to scan, which fails
+// the insert.
+func insertPriceTick(tx *gorm.DB, tick *model.PriceTick) error {
+	scope := tx.NewScope(tick)
+	sql := fmt.Sprintf("INSERT INTO %s (%s, %s, %s, %s) VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING",
+		scope.QuotedTableName(), scope.Quote("symbol"), scope.Quote("timestamp"), scope.Quote("price"), scope.Quote("signal"))
+	if err := tx.Exec(sql, tick.Symbol, tick.Timestamp, tick.Price, tick.Signal).Error; err != nil {
+		return &model.Error{Code: model.CodeInternal, Message: "Error recording price history", Err: err}
+	}
+	return nil
+}
+
+// appendColumns returns the UPDATE values of the price history columns of
+// trade once points are appended, appended being trade with the points
+// added. They append the points to the stored JSON arrays and drop the
+// entries trimmed to MaxDataSize from their front, so that only the new points
+// are sent to the database. The update must be guarded by the revision trade
+// was read at, since the entries to drop are counted from trade.
+func appendColumns(tx *gorm.DB, trade, appended *model.TradingSystem, points []model.PricePoint) (map[string]interface{}, error) {
+	prices := make([]interface{}, len(points))
+	timestamps := make([]interface{}, len(points))
+	signals := make([]interface{}, len(points))
+	for i, p := range points {
+		prices[i], timestamps[i], signals[i] = p.Price, p.Timestamp, p.Signal
+	}
+	cols := make(map[string]interface{}, 3)
+	for _, c := range []struct {
+		column        string
+		added         []interface{}
+		before, after int
+	}{
+		{"closing_prices", prices, len(trade.ClosingPrices), len(appended.ClosingPrices)},
+		{"timestamps", timestamps, len(trade.Timestamps), len(appended.Timestamps)},
+		{"signals", signals, len(trade.Signals), len(appended.Signals)},
+	} {
+		expr, err := appendExpr(tx, c.column, c.added, c.before+len(c.added)-c.after)
+		if err != nil {
+			return nil, err
+		}
+		cols[c.column] = expr
+	}
+	return cols, nil
+}
+
+// appendExpr returns the SQL expression adding added to the end of the JSON
+// array stored in column and removing drop entries from its front.
+func appendExpr(tx *gorm.DB, column string, added []interface{}, drop int) (*gorm.SqlExpr, error) {
+	col := tx.Dialect().Quote(column)
+	if tx.Dialect().GetName() == DriverPostgres {
+		b, err := json.Marshal(added)
+		if err != nil {
+			return nil, model.Errorf(model.CodeInvalidPayload, "Invalid price point: %v", err)
+		}
+		expr := fmt.Sprintf("COALESCE(NULLIF(%s, 'null'::jsonb), '[]'::jsonb) || ?::jsonb", col)
+		for i := 0; i < drop; i++ {
+			expr = "(" + expr + ") - 0"
+		}
+		return gorm.Expr(expr, string(b)), nil
+	}
+	// SQLite has no array concatenation: json_insert appends the elements
+	// one by one, and json_remove drops the first one drop times.
+	elems := make([]interface{}, len(added))
+	for i, v := range added {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, model.Errorf(model.CodeInvalidPayload, "Invalid price point: %v", err)
+		}
+		elems[i] = string(b)
+	}
+	expr := fmt.Sprintf("IFNULL(NULLIF(%s, 'null'), '[]')", col)
+	if len(elems) > 0 {
+		expr = "json_insert(" + expr + strings.Repeat(", '$[#]', json(?)", len(elems)) + ")"
+	}
+	if drop > 0 {
+		expr = "json_remove(" + expr + strings.Repeat(", '$[0]'", drop) + ")"
+	}
+	return gorm.Expr(expr, elems...), nil
+}
+
+// backfillPriceTicks copies the price history held in the JSON columns of
+// every trading system into the price_ticks table. It runs once, when
+// migration 3 creates the table, so it reads the price history columns as
+// migration 1 created them.
+func backfillPriceTicks(tx *gorm.DB) error {
+	var trades []*tradingSystemV1
+	err := tx.Unscoped().Select("id, symbol, closing_prices, timestamps, signals").Order("id ASC").Find(&trades).Error
+	if err != nil {
+		return fmt.Errorf("Error loading trading systems for price backfill: %v", err)
+	}
+	for _, t := range trades {
+		for i := 0; i < len(t.ClosingPrices) && i < len(t.Timestamps); i++ {
+			tick := model.PriceTick{Symbol: t.Symbol, Timestamp: t.Timestamps[i], Price: t.ClosingPrices[i]}
+			if i < len(t.Signals) {
+				tick.Signal = t.Signals[i]
+			}
+			if err := insertPriceTick(tx, &tick); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ReadPrices returns the price ticks selected by q.
+func (s *DBServices) ReadPrices(q model.PriceQuery) ([]model.PriceTick, error) {
+	if err := q.Normalize(); err != nil {
+		return nil, model.Errorf(model.CodeInvalidPayload, "Invalid price query: %v", err)
+	}
+	db := s.DB.Where("symbol = ?", q.Symbol)
+	if q.From != 0 {
+		db = db.Where("timestamp >= ?", q.From)
+	}
+	if q.To != 0 {
+		db = db.Where("timestamp <= ?", q.To)
+	}
+	if q.Interval > 0 {
+		// Keep the last tick of every bucket
+		buckets := db.Model(&model.PriceTick{}).
+			Select("MAX(timestamp)").
+			Group(fmt.Sprintf("timestamp / %d", q.Interval)).
+			SubQuery()
+		db = s.DB.Where("symbol = ?", q.Symbol).Where("timestamp IN ?", buckets)
+	}
+	ticks := []model.PriceTick{}
+	if err := db.Order("timestamp ASC").Limit(q.Limit).Find(&ticks).Error; err != nil {
+		return nil, &model.Error{Code: model.CodeInternal, Message: "Error reading price history", Err: err}
+	}
+	return ticks, nil
+}
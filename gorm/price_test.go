@@ -0,0 +1,93 @@
+package gorm_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	dbgorm "github.com/chidi150c/database/gorm"
+	"github.com/chidi150c/database/model"
+	"github.com/jinzhu/gorm"
+)
+
+func TestAppendPricesWritesOnlyNewPoints(t *testing.T) {
+	dbs := openTestDB(t, dbgorm.DriverSQLite, filepath.Join(t.TempDir(), "test.db"))
+	id, err := dbs.CreateTradingSystem(model.NewTradingSystem(&model.TradingSystemData{
+		Symbol:        "BTC",
+		MaxDataSize:   4,
+		ClosingPrices: []float64{101.5, 102.5, 103.5},
+		Timestamps:    []int64{1001, 1002, 1003},
+		Signals:       []string{"hold-1", "hold-2", "hold-3"},
+	}))
+	if err != nil {
+		t.Fatalf("CreateTradingSystem: %v", err)
+	}
+
+	// Capture what the append sends to the database
+	var sent []string
+	dbs.DB.Callback().Update().After("gorm:update").Register("test:capture", func(scope *gorm.Scope) {
+		sent = append(sent, scope.SQL)
+		for _, v := range scope.SQLVars {
+			sent = append(sent, fmt.Sprint(v))
+		}
+	})
+	trade, err := dbs.AppendPrices(id, []model.PricePoint{{Timestamp: 1004, Price: 104.5, Signal: "buy"}, {Timestamp: 1005, Price: 105.5, Signal: "sell"}})
+	if err != nil {
+		t.Fatalf("AppendPrices: %v", err)
+	}
+	if len(sent) == 0 {
+		t.Fatal("AppendPrices sent no UPDATE")
+	}
+	for _, s := range sent {
+		for _, old := range []string{"102.5", "1002", "hold-2"} {
+			if strings.Contains(s, old) {
+				t.Fatalf("AppendPrices sent the stored entry %s in %q", old, s)
+			}
+		}
+	}
+
+	got, err := dbs.ReadTradingSystem(id)
+	if err != nil {
+		t.Fatalf("ReadTradingSystem: %v", err)
+	}
+	for _, ts := range []*model.TradingSystem{trade, got} {
+		if want := []float64{102.5, 103.5, 104.5, 105.5}; !reflect.DeepEqual([]float64(ts.ClosingPrices), want) {
+			t.Fatalf("closing prices %v, want %v", ts.ClosingPrices, want)
+		}
+		if want := []int64{1002, 1003, 1004, 1005}; !reflect.DeepEqual([]int64(ts.Timestamps), want) {
+			t.Fatalf("timestamps %v, want %v", ts.Timestamps, want)
+		}
+		if want := []string{"hold-2", "hold-3", "buy", "sell"}; !reflect.DeepEqual([]string(ts.Signals), want) {
+			t.Fatalf("signals %v, want %v", ts.Signals, want)
+		}
+		if ts.Revision != 2 {
+			t.Fatalf("revision %d, want 2", ts.Revision)
+		}
+	}
+
+	// The history records the points, not the arrays
+	entries, _, err := dbs.ListHistory(model.HistoryQuery{ID: id, Cursor: "1"})
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("ListHistory = %v, %v, want the append", entries, err)
+	}
+	for _, c := range entries[0].Changes {
+		if c.Field != "closing_prices" {
+			continue
+		}
+		if c.Old != nil || c.New != nil || fmt.Sprint(c.Appended) != "[104.5 105.5]" || fmt.Sprint(c.Dropped) != "[101.5]" {
+			t.Fatalf("append recorded as %+v, want 104.5 and 105.5 appended and 101.5 dropped", c)
+		}
+		// and the revision before it is still rebuilt from them
+		old, err := dbs.ReadTradingSystemRevision(id, 1)
+		if err != nil {
+			t.Fatalf("ReadTradingSystemRevision: %v", err)
+		}
+		if want := []float64{101.5, 102.5, 103.5}; !reflect.DeepEqual([]float64(old.ClosingPrices), want) {
+			t.Fatalf("revision 1 has closing prices %v, want %v", old.ClosingPrices, want)
+		}
+		return
+	}
+	t.Fatalf("append recorded as %+v, want a closing_prices change", entries[0].Changes)
+}
@@ -0,0 +1,106 @@
+package gorm
+
+import (
+	"time"
+
+	"github.com/chidi150c/database/model"
+	"github.com/jinzhu/gorm"
+)
+
+// The tables as the migrations left them. A migration describes its table
+// with the copy of its own version, never with the live model, so that
+// changing a model cannot change what a released migration does. A change
+// to a model needs a new migration and, when AutoMigrate is used, a new copy.
+
+// tradingSystemV1 is trading_systems as created by migration 1.
+type tradingSystemV1 struct {
+	gorm.Model
+	Symbol                   string
+	ClosingPrices            model.Float64Slice `gorm:"type:json"`
+	Timestamps               model.Int64Slice   `gorm:"type:json"`
+	Signals                  model.StringSlice  `gorm:"type:json"`
+	NextInvestBuYPrice       model.Float64Slice `gorm:"type:json"`
+	NextProfitSeLLPrice      model.Float64Slice `gorm:"type:json"`
+	CommissionPercentage     float64
+	InitialCapital           float64
+	PositionSize             float64
+	EntryPrice               model.Float64Slice `gorm:"type:json"`
+	InTrade                  bool
+	QuoteBalance             float64
+	BaseBalance              float64
+	RiskCost                 float64
+	DataPoint                int
+	CurrentPrice             float64
+	EntryQuantity            model.Float64Slice `gorm:"type:json"`
+	EntryCostLoss            model.Float64Slice `gorm:"type:json"`
+	TradeCount               int
+	TradingLevel             int
+	ClosedWinTrades          int
+	EnableStoploss           bool
+	StopLossTrigered         bool
+	StopLossRecover          model.Float64Slice `gorm:"type:json"`
+	RiskFactor               float64
+	MaxDataSize              int
+	RiskProfitLossPercentage float64
+	BaseCurrency             string
+	QuoteCurrency            string
+	MiniQty                  float64
+	MaxQty                   float64
+	MinNotional              float64
+	StepSize                 float64
+	TargetStopLoss           float64
+	TargetProfit             float64
+	TotalProfitLoss          float64
+	RiskPositionPercentage   float64
+	ShortPeriod              int
+	LongPeriod               int
+}
+
+func (tradingSystemV1) TableName() string { return "trading_systems" }
+
+// tradingSystemV2 is trading_systems once migration 2 added the revision.
+// GORM skips unexported fields, so version 1 is embedded through an exported
+// one.
+type tradingSystemV2 struct {
+	TradingSystemV1 tradingSystemV1 `gorm:"embedded"`
+	Revision        uint            `gorm:"not null;default:1"`
+}
+
+func (tradingSystemV2) TableName() string { return "trading_systems" }
+
+// priceTickV3 is price_ticks as created by migration 3.
+type priceTickV3 struct {
+	ID        uint   `gorm:"primary_key"`
+	Symbol    string `gorm:"not null;unique_index:idx_price_ticks_symbol_timestamp"`
+	Timestamp int64  `gorm:"not null;unique_index:idx_price_ticks_symbol_timestamp"`
+	Price     float64
+	Signal    string
+}
+
+func (priceTickV3) TableName() string { return "price_ticks" }
+
+// tradingSystemHistoryV4 is trading_system_histories as created by migration 4.
+type tradingSystemHistoryV4 struct {
+	ID              uint   `gorm:"primary_key"`
+	TradingSystemID uint   `gorm:"not null;unique_index:idx_history_trading_system_revision"`
+	Revision        uint   `gorm:"not null;unique_index:idx_history_trading_system_revision"`
+	Action          string `gorm:"not null"`
+	Client          string
+	Changes         model.FieldChanges `gorm:"type:json"`
+	CreatedAt       time.Time
+}
+
+func (tradingSystemHistoryV4) TableName() string { return "trading_system_histories" }
+
+// apiKeyV6 is api_keys as created by migration 6.
+type apiKeyV6 struct {
+	ID        uint `gorm:"primary_key"`
+	Name      string
+	Prefix    string
+	Hash      string            `gorm:"unique_index"`
+	Scopes    model.StringSlice `gorm:"type:json"`
+	CreatedAt time.Time
+	RevokedAt *time.Time
+}
+
+func (apiKeyV6) TableName() string { return "api_keys" }
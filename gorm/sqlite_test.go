@@ -0,0 +1,30 @@
+package gorm_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/chidi150c/database/dbtest"
+	dbgorm "github.com/chidi150c/database/gorm"
+	"github.com/chidi150c/database/model"
+)
+
+func TestSQLiteConformance(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) model.DBServicer {
+		return openTestDB(t, dbgorm.DriverSQLite, filepath.Join(t.TempDir(), "test.db"))
+	})
+}
+
+// openTestDB opens and migrates a database that is closed with the test.
+func openTestDB(t *testing.T, driver, dsn string) *dbgorm.DBServices {
+	t.Helper()
+	dbs, err := dbgorm.Open(driver, dsn)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { dbs.Close() })
+	if err := dbs.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	return dbs
+}
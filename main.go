@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/chidi150c/database/gorm"
 	"github.com/chidi150c/database/policy"
@@ -13,30 +18,209 @@ func main() {
 	// Get the port and host site from environment variables
 	port := os.Getenv("PORT3")
 
-	// Initialize your DBServices
-	dbName := "myapp.db" // Replace with your desired database name
-	
-	dbs, err := gorm.NewDBServices(dbName)
+	// Initialize your DBServices. SQLite is the default; set
+	// DB_DRIVER=postgres and DATABASE_URL to share the data between
+	// several bot instances.
+	driver := os.Getenv("DB_DRIVER")
+	if driver == "" {
+		driver = gorm.DriverSQLite
+	}
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" && driver == gorm.DriverSQLite {
+		dsn = "myapp.db" // Replace with your desired database name
+	}
+
+	dbs, err := gorm.Open(driver, dsn)
 	if err != nil {
 		log.Fatalf("Failed to initialize DBServices: %v", err)
 	}
 
-	// Check if the required tables (TradingSystem and AppData) exist in the database.
-	// If they don't exist, create them.
-	if err := dbs.CheckAndCreateTables(); err != nil {
-		log.Fatalf("Error creating database tables: %v", err)
+	// Move the schema to a given version, for example to roll back the
+	// migrations of a release before downgrading the binary:
+	//   mydbapp migrate <version>
+	if len(os.Args) == 3 && os.Args[1] == "migrate" {
+		version, err := strconv.Atoi(os.Args[2])
+		if err != nil {
+			log.Fatalf("Invalid schema version %q", os.Args[2])
+		}
+		err = dbs.MigrateTo(version)
+		if cerr := dbs.Close(); cerr != nil {
+			log.Printf("Error closing database: %v", cerr)
+		}
+		if err != nil {
+			log.Fatalf("Error migrating database: %v", err)
+		}
+		log.Printf("Database schema at version %d", version)
+		return
+	}
+
+	// Bring the database schema up to date. This refuses to run against a
+	// schema written by a newer binary.
+	if err := dbs.Migrate(); err != nil {
+		log.Fatalf("Error migrating database: %v", err)
+	}
+	// Re-import an archive written by the retention policy:
+	//   mydbapp restore-archive <file>
+	if len(os.Args) == 3 && os.Args[1] == "restore-archive" {
+		report, err := policy.RestoreArchive(dbs, os.Args[2])
+		if cerr := dbs.Close(); cerr != nil {
+			log.Printf("Error closing database: %v", cerr)
+		}
+		if err != nil {
+			log.Fatalf("Error restoring archive: %v", err)
+		}
+		log.Printf("Restored %d trading systems %v, skipped %d still in use %v", len(report.Restored), report.Restored, len(report.Skipped), report.Skipped)
+		return
+	}
+
+	// Configure the retention policy, e.g.
+	// RETENTION_RULES=keep_latest_per_symbol=500,max_age=720h,purge_deleted=168h
+	retention := policy.Config{
+		Rules:      policy.DefaultRules,
+		DryRun:     os.Getenv("RETENTION_DRY_RUN") == "true",
+		ArchiveDir: os.Getenv("RETENTION_ARCHIVE_DIR"),
+	}
+	if rules := os.Getenv("RETENTION_RULES"); rules != "" {
+		if retention.Rules, err = policy.ParseRules(rules); err != nil {
+			log.Fatalf("Invalid RETENTION_RULES: %v", err)
+		}
 	}
-    // Start the scheduled retention task
-    go policy.ScheduleRetentionTask(dbs)
+	// Shut down gracefully on SIGINT or SIGTERM, e.g. during a deploy
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// Initialize your TradeHandler
-	th := server.NewTradeHandler(dbName)
+	// Start the scheduled retention task
+	retentionDone := make(chan struct{})
+	go func() {
+		policy.ScheduleRetentionTask(ctx, dbs, retention)
+		close(retentionDone)
+	}()
+
+	// Initialize your TradeHandler around the shared DBServices
+	th := server.NewTradeHandler(dbs)
+	// Bound what a slow WebSocket client can hold up, e.g.
+	// WS_QUEUE_SIZE=64 WS_QUEUE_POLICY=drop-oldest WS_WRITE_TIMEOUT=10s
+	if size := os.Getenv("WS_QUEUE_SIZE"); size != "" {
+		if th.Writer.QueueSize, err = strconv.Atoi(size); err != nil {
+			log.Fatalf("Invalid WS_QUEUE_SIZE %q", size)
+		}
+	}
+	if name := os.Getenv("WS_QUEUE_POLICY"); name != "" {
+		if th.Writer.Policy, err = server.ParseQueuePolicy(name); err != nil {
+			log.Fatalf("Invalid WS_QUEUE_POLICY: %v", err)
+		}
+	}
+	if timeout := os.Getenv("WS_WRITE_TIMEOUT"); timeout != "" {
+		if th.Writer.WriteTimeout, err = time.ParseDuration(timeout); err != nil {
+			log.Fatalf("Invalid WS_WRITE_TIMEOUT %q", timeout)
+		}
+	}
+	// Require an API key from every client, unless AUTH_DISABLED=true.
+	// API_BOOTSTRAP_KEY is stored as an admin key so that the first keys
+	// can be created with the create-key action, see the README.
+	if os.Getenv("AUTH_DISABLED") != "true" {
+		th.Keys = dbs
+		if token := os.Getenv("API_BOOTSTRAP_KEY"); token != "" {
+			if err := server.BootstrapAPIKey(dbs, token); err != nil {
+				log.Fatalf("Invalid API_BOOTSTRAP_KEY: %v", err)
+			}
+			log.Println("API_BOOTSTRAP_KEY is an admin key; create the client keys with it, then remove it")
+		} else if keys, err := dbs.ListAPIKeys(); err != nil {
+			log.Fatalf("Error listing API keys: %v", err)
+		} else if len(keys) == 0 {
+			log.Fatal("No API key exists yet and no client could connect; set API_BOOTSTRAP_KEY to create an admin key, or AUTH_DISABLED=true")
+		}
+	} else {
+		log.Println("AUTH_DISABLED=true: clients are served without an API key")
+	}
+	// Only let browser pages from our own sites use the service.
+	// ALLOWED_ORIGINS, which defaults to HOSTSITE, is the production list,
+	// e.g. https://resoledge.com,https://*.resoledge.com. With APP_ENV=dev
+	// the DEV_ALLOWED_ORIGINS list, local dashboards by default, is allowed
+	// too. Clients that send no Origin, such as bots, are not affected.
+	origins := server.SplitOrigins(os.Getenv("ALLOWED_ORIGINS"))
+	if len(origins) == 0 {
+		origins = server.SplitOrigins(os.Getenv("HOSTSITE"))
+	}
+	if os.Getenv("APP_ENV") == "dev" {
+		dev := os.Getenv("DEV_ALLOWED_ORIGINS")
+		if dev == "" {
+			dev = server.DefaultDevOrigins
+		}
+		origins = append(origins, server.SplitOrigins(dev)...)
+	}
+	if th.Origins, err = server.NewOriginPolicy(origins); err != nil {
+		log.Fatalf("Invalid allowed origins: %v", err)
+	}
+	log.Printf("Allowed browser origins: %v", origins)
+	// Limit the WebSocket operations processed at once, e.g. WS_CONCURRENCY=32
+	if concurrency := os.Getenv("WS_CONCURRENCY"); concurrency != "" {
+		if th.Concurrency, err = strconv.Atoi(concurrency); err != nil {
+			log.Fatalf("Invalid WS_CONCURRENCY %q", concurrency)
+		}
+	}
+
+	// Client certificates verified against TLS_CLIENT_CA_FILE are granted
+	// the scopes of their identity, the common name of the subject, e.g.
+	// MTLS_CLIENT_SCOPES=bot-1=read,write;dashboard=read;*=read
+	if list := os.Getenv("MTLS_CLIENT_SCOPES"); list != "" {
+		if th.CertScopes, err = server.ParseCertScopes(list); err != nil {
+			log.Fatalf("Invalid MTLS_CLIENT_SCOPES: %v", err)
+		}
+	}
 
 	// Setup and Start Web Server
-	server := server.NewServer(port, th)
+	srv := server.NewServer(port, th)
+	// Serve TLS with TLS_CERT_FILE and TLS_KEY_FILE. The files, and the
+	// TLS_CLIENT_CA_FILE bundle, are reloaded when they change, checked
+	// every TLS_RELOAD_INTERVAL. TLS_REQUIRE_CLIENT_CERT=true refuses the
+	// clients without a valid certificate.
+	if certFile := os.Getenv("TLS_CERT_FILE"); certFile != "" {
+		srv.TLS = &server.TLSConfig{
+			CertFile:          certFile,
+			KeyFile:           os.Getenv("TLS_KEY_FILE"),
+			ClientCAFile:      os.Getenv("TLS_CLIENT_CA_FILE"),
+			RequireClientCert: os.Getenv("TLS_REQUIRE_CLIENT_CERT") == "true",
+		}
+		if interval := os.Getenv("TLS_RELOAD_INTERVAL"); interval != "" {
+			if srv.TLS.ReloadInterval, err = time.ParseDuration(interval); err != nil {
+				log.Fatalf("Invalid TLS_RELOAD_INTERVAL %q", interval)
+			}
+		}
+	}
+
+	// Give the operations in flight SHUTDOWN_TIMEOUT, 30s by default, to
+	// finish once a signal is received.
+	shutdownTimeout := 30 * time.Second
+	if timeout := os.Getenv("SHUTDOWN_TIMEOUT"); timeout != "" {
+		if shutdownTimeout, err = time.ParseDuration(timeout); err != nil {
+			log.Fatalf("Invalid SHUTDOWN_TIMEOUT %q", timeout)
+		}
+	}
 
 	// Start the web server
-	if err := server.Open(); err != nil {
+	served := make(chan error, 1)
+	go func() { served <- srv.Open() }()
+	select {
+	case err = <-served:
+	case <-ctx.Done():
+		log.Println("Received shutdown signal")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Error shutting down server: %v", err)
+		}
+		cancel()
+		err = <-served
+	}
+
+	// Stop the retention task before closing the database under it
+	stop()
+	<-retentionDone
+	if cerr := dbs.Close(); cerr != nil {
+		log.Printf("Error closing database: %v", cerr)
+	}
+	if err != nil {
 		log.Fatalf("Unable to open server for listen and serve: %v", err)
 	}
+	log.Println("Server stopped")
 }
@@ -0,0 +1,71 @@
+package memory
+
+import (
+	"time"
+
+	"github.com/chidi150c/database/model"
+)
+
+var _ model.APIKeyServicer = &DBServices{}
+
+func (s *DBServices) CreateAPIKey(key *model.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range s.apiKeys {
+		if k.Hash == key.Hash {
+			return model.Errorf(model.CodeConflict, "An API key with this token already exists")
+		}
+	}
+	s.lastAPIKeyID++
+	key.ID = s.lastAPIKeyID
+	key.CreatedAt = time.Now()
+	s.apiKeys[key.ID] = cloneAPIKey(key)
+	return nil
+}
+
+func (s *DBServices) FindAPIKey(hash string) (*model.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, k := range s.apiKeys {
+		if k.Hash == hash {
+			return cloneAPIKey(k), nil
+		}
+	}
+	return nil, model.Errorf(model.CodeNotFound, "API key not found")
+}
+
+func (s *DBServices) ListAPIKeys() ([]*model.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]*model.APIKey, 0, len(s.apiKeys))
+	for id := uint(1); id <= s.lastAPIKeyID; id++ {
+		if k, ok := s.apiKeys[id]; ok {
+			keys = append(keys, cloneAPIKey(k))
+		}
+	}
+	return keys, nil
+}
+
+func (s *DBServices) RevokeAPIKey(id uint) (*model.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.apiKeys[id]
+	if !ok {
+		return nil, model.Errorf(model.CodeNotFound, "API key with ID %d not found", id)
+	}
+	if !k.Revoked() {
+		now := time.Now()
+		k.RevokedAt = &now
+	}
+	return cloneAPIKey(k), nil
+}
+
+func cloneAPIKey(k *model.APIKey) *model.APIKey {
+	c := *k
+	c.Scopes = append(model.StringSlice(nil), k.Scopes...)
+	if k.RevokedAt != nil {
+		revokedAt := *k.RevokedAt
+		c.RevokedAt = &revokedAt
+	}
+	return &c
+}
@@ -0,0 +1,56 @@
+package memory
+
+import (
+	"strings"
+	"time"
+
+	"github.com/chidi150c/database/model"
+)
+
+// matches reports whether t passes the filters of f.
+func matches(f *model.TradingSystemFilter, t *model.TradingSystem) bool {
+	switch {
+	case f.Symbol != nil && t.Symbol != *f.Symbol,
+		f.BaseCurrency != nil && t.BaseCurrency != *f.BaseCurrency,
+		f.QuoteCurrency != nil && t.QuoteCurrency != *f.QuoteCurrency,
+		f.InTrade != nil && t.InTrade != *f.InTrade,
+		f.StopLossTrigered != nil && t.StopLossTrigered != *f.StopLossTrigered,
+		f.CreatedAfter != nil && t.CreatedAt.Before(*f.CreatedAfter),
+		f.CreatedBefore != nil && !t.CreatedAt.Before(*f.CreatedBefore),
+		f.UpdatedAfter != nil && t.UpdatedAt.Before(*f.UpdatedAfter),
+		f.UpdatedBefore != nil && !t.UpdatedAt.Before(*f.UpdatedBefore):
+		return false
+	}
+	return true
+}
+
+// compare orders two sort values of the same type the way the database
+// orders the matching column: it returns -1, 0 or +1 when a is less than,
+// equal to or greater than b.
+func compare(a, b interface{}) int {
+	switch a := a.(type) {
+	case uint:
+		return compareOrdered(a < b.(uint), a > b.(uint))
+	case int:
+		return compareOrdered(a < b.(int), a > b.(int))
+	case float64:
+		return compareOrdered(a < b.(float64), a > b.(float64))
+	case string:
+		return strings.Compare(a, b.(string))
+	case bool:
+		return compareOrdered(!a && b.(bool), a && !b.(bool))
+	case time.Time:
+		return compareOrdered(a.Before(b.(time.Time)), a.After(b.(time.Time)))
+	}
+	return 0
+}
+
+func compareOrdered(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,472 @@
+// Package memory implements model.DBServicer in memory, for tests and for
+// embedding the trading system store in a process without a database. It
+// follows the semantics of the gorm package: IDs are never reused, deleted
+// trading systems are soft-deleted and keep their history, and ID 0 reads the
+// latest trading system. Errors are the typed errors of the gorm package.
+package memory
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/chidi150c/database/model"
+)
+
+// DBServices is an in-memory implementation of the DBServicer interface. It
+// is safe for concurrent use.
+type DBServices struct {
+	*store
+
+	// client is recorded as the origin of writes in the history.
+	client string
+}
+
+// store holds the data shared by a DBServices and the views returned by
+// WithClient.
+type store struct {
+	mu sync.RWMutex
+
+	lastID        uint
+	trades        map[uint]*model.TradingSystem // including soft-deleted ones
+	history       map[uint][]*model.TradingSystemHistory
+	lastHistoryID uint
+
+	// ticks holds the price history of every symbol ordered by timestamp.
+	ticks      map[string][]model.PriceTick
+	lastTickID uint
+
+	apiKeys      map[uint]*model.APIKey
+	lastAPIKeyID uint
+}
+
+var _ model.DBServicer = &DBServices{}
+
+// NewDBServices returns an empty in-memory store.
+func NewDBServices() *DBServices {
+	return &DBServices{store: &store{
+		trades:  make(map[uint]*model.TradingSystem),
+		history: make(map[uint][]*model.TradingSystemHistory),
+		ticks:   make(map[string][]model.PriceTick),
+		apiKeys: make(map[uint]*model.APIKey),
+	}}
+}
+
+// WithClient returns a DBServices sharing the data of s that records client
+// as the origin of its writes in the history.
+func (s *DBServices) WithClient(client string) model.DBServicer {
+	return &DBServices{store: s.store, client: client}
+}
+
+func (s *DBServices) CreateTradingSystem(trade *model.TradingSystem) (uint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if trade.ID == 0 {
+		trade.ID = s.lastID + 1
+	} else if _, ok := s.trades[trade.ID]; ok {
+		return 0, &model.Error{Code: model.CodeInternal, Message: "Error creating trading system", Err: fmt.Errorf("ID %d already exists", trade.ID)}
+	}
+	if trade.ID > s.lastID {
+		s.lastID = trade.ID
+	}
+	now := time.Now()
+	trade.Revision = 1
+	trade.CreatedAt, trade.UpdatedAt, trade.DeletedAt = now, now, nil
+	s.trades[trade.ID] = clone(trade)
+	s.recordHistory(s.client, model.HistoryCreate, trade, model.Diff(&model.TradingSystem{}, trade))
+	s.syncPriceTicks(trade)
+	return trade.ID, nil
+}
+
+func (s *DBServices) ReadTradingSystem(tradeID uint) (*model.TradingSystem, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if tradeID == 0 {
+		var latest *model.TradingSystem
+		for _, t := range s.trades {
+			if t.DeletedAt == nil && (latest == nil || t.ID > latest.ID) {
+				latest = t
+			}
+		}
+		if latest == nil {
+			return nil, model.Errorf(model.CodeNotFound, "No trading system entry found")
+		}
+		return clone(latest), nil
+	}
+	trade, err := s.live(tradeID)
+	if err != nil {
+		return nil, err
+	}
+	return clone(trade), nil
+}
+
+// ListTradingSystems returns one page of the trading systems matching filter
+// and the cursor of the next page, which is empty on the last page.
+func (s *DBServices) ListTradingSystems(filter model.TradingSystemFilter) ([]*model.TradingSystem, string, error) {
+	if err := filter.Normalize(); err != nil {
+		return nil, "", model.Errorf(model.CodeInvalidPayload, "Invalid list filter: %v", err)
+	}
+	var after interface{}
+	var afterID uint
+	if filter.Cursor != "" {
+		var err error
+		if after, afterID, err = filter.DecodeCursor(); err != nil {
+			return nil, "", model.Errorf(model.CodeInvalidPayload, "Invalid list filter: %v", err)
+		}
+	}
+	desc := filter.Order == "desc"
+	// less orders trading systems by the sort value, then by ID
+	less := func(av interface{}, aID uint, bv interface{}, bID uint) bool {
+		if c := compare(av, bv); c != 0 {
+			return (c < 0) != desc
+		}
+		return aID != bID && (aID < bID) != desc
+	}
+
+	s.mu.RLock()
+	var trades []*model.TradingSystem
+	for _, t := range s.trades {
+		if t.DeletedAt != nil || !matches(&filter, t) {
+			continue
+		}
+		if filter.Cursor != "" && !less(after, afterID, filter.SortValue(t), t.ID) {
+			continue
+		}
+		trades = append(trades, clone(t))
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(trades, func(i, j int) bool {
+		return less(filter.SortValue(trades[i]), trades[i].ID, filter.SortValue(trades[j]), trades[j].ID)
+	})
+	next := ""
+	if len(trades) > filter.Limit {
+		trades = trades[:filter.Limit]
+		next = filter.EncodeCursor(trades[len(trades)-1])
+	}
+	return trades, next, nil
+}
+
+// UpdateTradingSystem overwrites the stored trading system with trade. When
+// trade.Revision is not zero it must equal the stored revision, otherwise a
+// CodeConflict error carrying the current revision is returned. On success
+// trade holds the stored state, including its new revision.
+func (s *DBServices) UpdateTradingSystem(trade *model.TradingSystem) error {
+	if trade.ID == 0 {
+		return model.Errorf(model.CodeInvalidPayload, "TradingSystem ID is required for update")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing, err := s.live(trade.ID)
+	if err != nil {
+		return err
+	}
+	if trade.Revision != 0 && trade.Revision != existing.Revision {
+		return model.ConflictError(trade.ID, trade.Revision, existing.Revision)
+	}
+	*trade = *s.write(model.HistoryUpdate, existing, trade)
+	return nil
+}
+
+// PatchTradingSystem applies the JSON merge patch to the trading system.
+func (s *DBServices) PatchTradingSystem(tradeID uint, patch []byte) (*model.TradingSystem, error) {
+	if tradeID == 0 {
+		return nil, model.Errorf(model.CodeInvalidPayload, "TradingSystem ID is required for patch")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing, err := s.live(tradeID)
+	if err != nil {
+		return nil, err
+	}
+	patched, err := existing.ApplyPatch(patch)
+	if err != nil {
+		return nil, model.Errorf(model.CodeInvalidPayload, "Invalid patch: %v", err)
+	}
+	if patched.Revision != existing.Revision {
+		return nil, model.ConflictError(tradeID, patched.Revision, existing.Revision)
+	}
+	if err := patched.Data().Validate(); err != nil {
+		return nil, model.Errorf(model.CodeInvalidPayload, "Invalid patch: %v", err)
+	}
+	return s.write(model.HistoryPatch, existing, patched), nil
+}
+
+// AppendPrices adds price points to the history of the trading system,
+// trimming it to MaxDataSize.
+func (s *DBServices) AppendPrices(tradeID uint, points []model.PricePoint) (*model.TradingSystem, error) {
+	if tradeID == 0 {
+		return nil, model.Errorf(model.CodeInvalidPayload, "TradingSystem ID is required for append-price")
+	}
+	if len(points) == 0 {
+		return nil, model.Errorf(model.CodeInvalidPayload, "No price points to append")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing, err := s.live(tradeID)
+	if err != nil {
+		return nil, err
+	}
+	appended := clone(existing)
+	changes := appended.AppendPrices(points)
+	return s.writeChanges(model.HistoryAppendPrice, existing, appended, changes), nil
+}
+
+// ReadPrices returns the price ticks selected by q.
+func (s *DBServices) ReadPrices(q model.PriceQuery) ([]model.PriceTick, error) {
+	if err := q.Normalize(); err != nil {
+		return nil, model.Errorf(model.CodeInvalidPayload, "Invalid price query: %v", err)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ticks := []model.PriceTick{}
+	for _, tick := range s.ticks[q.Symbol] {
+		if (q.From != 0 && tick.Timestamp < q.From) || (q.To != 0 && tick.Timestamp > q.To) {
+			continue
+		}
+		// Keep the last tick of every bucket
+		if n := len(ticks); q.Interval > 0 && n > 0 && ticks[n-1].Timestamp/q.Interval == tick.Timestamp/q.Interval {
+			ticks[n-1] = tick
+			continue
+		}
+		ticks = append(ticks, tick)
+	}
+	if len(ticks) > q.Limit {
+		ticks = ticks[:q.Limit]
+	}
+	return ticks, nil
+}
+
+func (s *DBServices) DeleteTradingSystem(tradeID uint) error {
+	if tradeID == 0 {
+		return model.Errorf(model.CodeInvalidPayload, "TradingSystem ID is required for delete")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing, err := s.live(tradeID)
+	if err != nil {
+		return err
+	}
+	// The deletion is a revision of its own, recording the last state as the
+	// old values
+	deleted := clone(existing)
+	deleted.Revision++
+	now := time.Now()
+	deleted.DeletedAt = &now
+	s.trades[tradeID] = deleted
+	s.recordHistory(s.client, model.HistoryDelete, deleted, model.Diff(existing, &model.TradingSystem{}))
+	return nil
+}
+
+// ListHistory returns one page of the history of a trading system in
+// ascending revision order and the cursor of the next page.
+func (s *DBServices) ListHistory(query model.HistoryQuery) ([]*model.TradingSystemHistory, string, error) {
+	if err := query.Normalize(); err != nil {
+		return nil, "", model.Errorf(model.CodeInvalidPayload, "Invalid history query: %v", err)
+	}
+	after, _ := query.AfterRevision()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.trades[query.ID]; !ok && len(s.history[query.ID]) == 0 {
+		return nil, "", model.Errorf(model.CodeNotFound, "TradingSystem with ID %d not found", query.ID)
+	}
+	var entries []*model.TradingSystemHistory
+	for _, e := range s.history[query.ID] {
+		if e.Revision > after {
+			entry := *e
+			entries = append(entries, &entry)
+		}
+	}
+	next := ""
+	if len(entries) > query.Limit {
+		entries = entries[:query.Limit]
+		next = model.HistoryCursor(entries[len(entries)-1])
+	}
+	return entries, next, nil
+}
+
+// ReadTradingSystemRevision returns the trading system as it was at revision.
+func (s *DBServices) ReadTradingSystemRevision(tradeID uint, revision uint) (*model.TradingSystem, error) {
+	if tradeID == 0 || revision == 0 {
+		return nil, model.Errorf(model.CodeInvalidPayload, "TradingSystem ID and revision are required")
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	trade, ok := s.trades[tradeID]
+	if !ok {
+		return nil, model.Errorf(model.CodeNotFound, "TradingSystem with ID %d not found", tradeID)
+	}
+	return s.rewind(trade, revision)
+}
+
+// ReadTradingSystemAsOf returns the trading system as it was at asOf, that is
+// at the last revision written no later than asOf.
+func (s *DBServices) ReadTradingSystemAsOf(tradeID uint, asOf time.Time) (*model.TradingSystem, error) {
+	if tradeID == 0 {
+		return nil, model.Errorf(model.CodeInvalidPayload, "TradingSystem ID is required")
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	trade, ok := s.trades[tradeID]
+	if !ok {
+		return nil, model.Errorf(model.CodeNotFound, "TradingSystem with ID %d not found", tradeID)
+	}
+	revision := trade.Revision
+	if history := s.history[tradeID]; len(history) > 0 {
+		// Before the first entry the trading system was at the revision
+		// preceding it
+		revision = history[0].Revision - 1
+		for _, e := range history {
+			if e.CreatedAt.After(asOf) {
+				break
+			}
+			revision = e.Revision
+		}
+	}
+	if trade.CreatedAt.After(asOf) || revision == 0 {
+		return nil, model.Errorf(model.CodeNotFound, "TradingSystem with ID %d did not exist at %s", tradeID, asOf.Format(time.RFC3339))
+	}
+	return s.rewind(trade, revision)
+}
+
+// RevertTradingSystem writes the state the trading system had at revision
+// back as a new revision.
+func (s *DBServices) RevertTradingSystem(tradeID uint, revision uint) (*model.TradingSystem, error) {
+	if tradeID == 0 || revision == 0 {
+		return nil, model.Errorf(model.CodeInvalidPayload, "TradingSystem ID and revision are required for revert")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing, err := s.live(tradeID)
+	if err != nil {
+		return nil, err
+	}
+	target, err := s.rewind(existing, revision)
+	if err != nil {
+		return nil, err
+	}
+	return s.write(model.HistoryRevert, existing, target), nil
+}
+
+// live returns the stored trading system tradeID unless it was deleted. The
+// caller holds the lock and must not modify the result.
+func (s *store) live(tradeID uint) (*model.TradingSystem, error) {
+	trade, ok := s.trades[tradeID]
+	if !ok || trade.DeletedAt != nil {
+		return nil, model.Errorf(model.CodeNotFound, "TradingSystem with ID %d not found", tradeID)
+	}
+	return trade, nil
+}
+
+// write stores the data fields of next as the new revision of existing and
+// records the changes under action. Nothing is written when there are no
+// changes. It returns a copy of the stored state. The caller holds the lock.
+func (s *DBServices) write(action string, existing, next *model.TradingSystem) *model.TradingSystem {
+	return s.writeChanges(action, existing, next, model.Diff(existing, next))
+}
+
+// writeChanges is write with the changes from existing to next given, for
+// writes recorded as deltas. The caller holds the lock.
+func (s *DBServices) writeChanges(action string, existing, next *model.TradingSystem, changes []model.FieldChange) *model.TradingSystem {
+	if len(changes) == 0 {
+		return clone(existing)
+	}
+	stored := clone(next)
+	stored.Model = existing.Model
+	stored.Revision = existing.Revision + 1
+	stored.UpdatedAt = time.Now()
+	s.trades[stored.ID] = stored
+	s.recordHistory(s.client, action, stored, changes)
+	for _, c := range changes {
+		if c.Column == "closing_prices" || c.Column == "timestamps" || c.Column == "symbol" {
+			s.syncPriceTicks(stored)
+			break
+		}
+	}
+	return clone(stored)
+}
+
+// recordHistory appends the history entry of the revision trade was just
+// brought to. The caller holds the lock.
+func (s *store) recordHistory(client, action string, trade *model.TradingSystem, changes []model.FieldChange) {
+	s.lastHistoryID++
+	entry := model.NewHistory(action, client, trade, changes)
+	entry.ID = s.lastHistoryID
+	entry.CreatedAt = time.Now()
+	s.history[trade.ID] = append(s.history[trade.ID], entry)
+}
+
+// syncPriceTicks records the ticks of trade that are newer than the last tick
+// stored for its symbol. The caller holds the lock.
+func (s *store) syncPriceTicks(trade *model.TradingSystem) {
+	if trade.Symbol == "" {
+		return
+	}
+	ticks := s.ticks[trade.Symbol]
+	added := false
+	for _, tick := range trade.PriceTicks() {
+		if n := len(ticks); n > 0 && tick.Timestamp <= ticks[n-1].Timestamp {
+			continue
+		}
+		s.lastTickID++
+		tick.ID = s.lastTickID
+		ticks = append(ticks, tick)
+		added = true
+	}
+	if added {
+		sort.SliceStable(ticks, func(i, j int) bool { return ticks[i].Timestamp < ticks[j].Timestamp })
+	}
+	s.ticks[trade.Symbol] = ticks
+}
+
+// rewind returns trade as it was at revision by undoing the history entries
+// written after it. The caller holds the lock.
+func (s *store) rewind(trade *model.TradingSystem, revision uint) (*model.TradingSystem, error) {
+	if revision > trade.Revision {
+		return nil, model.Errorf(model.CodeNotFound, "TradingSystem with ID %d has no revision %d", trade.ID, revision)
+	}
+	var entries []*model.TradingSystemHistory
+	for _, e := range s.history[trade.ID] {
+		if e.Revision >= revision {
+			entries = append(entries, e)
+		}
+	}
+	// The entry of revision itself tells whether it was a deletion
+	deleted := revision == trade.Revision && trade.DeletedAt != nil
+	if len(entries) > 0 && entries[0].Revision == revision {
+		deleted = entries[0].Action == model.HistoryDelete
+		entries = entries[1:]
+	}
+	if deleted {
+		return nil, model.Errorf(model.CodeNotFound, "TradingSystem with ID %d was deleted at revision %d", trade.ID, revision)
+	}
+	if uint(len(entries)) != trade.Revision-revision {
+		return nil, model.Errorf(model.CodeNotFound, "History of TradingSystem with ID %d does not go back to revision %d", trade.ID, revision)
+	}
+	rewound, err := trade.Rewind(entries)
+	if err != nil {
+		return nil, &model.Error{Code: model.CodeInternal, Message: fmt.Sprintf("Error rebuilding revision %d of TradingSystem with ID %d", revision, trade.ID), Err: err}
+	}
+	rewound.DeletedAt = nil
+	return rewound, nil
+}
+
+// clone returns a copy of t that shares no slices with it, so stored trading
+// systems cannot be changed through the values handed to callers.
+func clone(t *model.TradingSystem) *model.TradingSystem {
+	c := *t
+	v := reflect.ValueOf(&c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		if f.Kind() == reflect.Slice && !f.IsNil() {
+			f.Set(reflect.AppendSlice(reflect.MakeSlice(f.Type(), 0, f.Len()), f))
+		}
+	}
+	if t.DeletedAt != nil {
+		deletedAt := *t.DeletedAt
+		c.DeletedAt = &deletedAt
+	}
+	return &c
+}
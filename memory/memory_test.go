@@ -0,0 +1,15 @@
+package memory_test
+
+import (
+	"testing"
+
+	"github.com/chidi150c/database/dbtest"
+	"github.com/chidi150c/database/memory"
+	"github.com/chidi150c/database/model"
+)
+
+func TestConformance(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) model.DBServicer {
+		return memory.NewDBServices()
+	})
+}
@@ -0,0 +1,137 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// API key scopes. ScopeAdmin grants every other scope as well as the
+// management of the keys themselves.
+const (
+	ScopeRead   = "read"
+	ScopeWrite  = "write"
+	ScopeDelete = "delete"
+	ScopeAdmin  = "admin"
+)
+
+// Scopes lists every valid scope.
+var Scopes = []string{ScopeRead, ScopeWrite, ScopeDelete, ScopeAdmin}
+
+// APIKeyPrefixLen is the number of leading characters of a token kept in
+// APIKey.Prefix to tell the keys apart.
+const APIKeyPrefixLen = 8
+
+// APIKey grants its scopes to the clients that present its token. Only the
+// SHA-256 hash of the token is stored; the token itself is shown once, when
+// the key is created.
+type APIKey struct {
+	ID        uint        `gorm:"primary_key" json:"id"`
+	Name      string      `json:"name"`
+	Prefix    string      `json:"prefix"`
+	Hash      string      `gorm:"unique_index" json:"-"`
+	Scopes    StringSlice `gorm:"type:json" json:"scopes"`
+	CreatedAt time.Time   `json:"created_at"`
+	RevokedAt *time.Time  `json:"revoked_at,omitempty"`
+}
+
+// NewAPIKey generates a random token and returns it with the key to store
+// for it.
+func NewAPIKey(name string, scopes []string) (*APIKey, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	key, err := NewAPIKeyFromToken(name, token, scopes)
+	if err != nil {
+		return nil, "", err
+	}
+	return key, token, nil
+}
+
+// NewAPIKeyFromToken returns the key to store for a token chosen by the
+// caller, such as a bootstrap key read from the environment.
+func NewAPIKeyFromToken(name, token string, scopes []string) (*APIKey, error) {
+	if name == "" {
+		return nil, errors.New("API key name is required")
+	}
+	if len(token) < 2*APIKeyPrefixLen {
+		return nil, fmt.Errorf("API key token must be at least %d characters", 2*APIKeyPrefixLen)
+	}
+	if err := ValidateScopes(scopes); err != nil {
+		return nil, fmt.Errorf("API key: %v", err)
+	}
+	return &APIKey{
+		Name:   name,
+		Prefix: token[:APIKeyPrefixLen],
+		Hash:   HashAPIKey(token),
+		Scopes: append(StringSlice(nil), scopes...),
+	}, nil
+}
+
+// HashAPIKey returns the hash stored for token. Tokens are random, so a
+// plain SHA-256 is enough to keep them from being read back.
+func HashAPIKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func validScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Revoked reports whether the key was revoked.
+func (k *APIKey) Revoked() bool {
+	return k.RevokedAt != nil
+}
+
+// HasScope reports whether the key grants scope.
+func (k *APIKey) HasScope(scope string) bool {
+	return HasScope(k.Scopes, scope)
+}
+
+// HasScope reports whether scopes grant scope, directly or through
+// ScopeAdmin.
+func HasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateScopes checks that scopes is a non-empty list of known scopes.
+func ValidateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if !validScope(scope) {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	return nil
+}
+
+// APIKeyServicer stores the API keys.
+type APIKeyServicer interface {
+	// CreateAPIKey stores key and sets its ID.
+	CreateAPIKey(key *APIKey) error
+	// FindAPIKey returns the key with the given token hash, revoked or
+	// not.
+	FindAPIKey(hash string) (*APIKey, error)
+	ListAPIKeys() ([]*APIKey, error)
+	// RevokeAPIKey revokes the key; revoking it again is not an error.
+	RevokeAPIKey(id uint) (*APIKey, error)
+}
@@ -4,10 +4,34 @@ import (
     "database/sql/driver"
     "encoding/json"
     "errors"
+	"time"
+
 	"github.com/jinzhu/gorm"
 )
 
 
+// jsonValue encodes v for a JSON column. The encoding is passed as a string:
+// PostgreSQL would take []byte for bytea rather than json/jsonb.
+func jsonValue(v interface{}) (driver.Value, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// jsonBytes returns the JSON read from a JSON column, which drivers hand over
+// as either []byte or string.
+func jsonBytes(value interface{}) ([]byte, bool) {
+	switch v := value.(type) {
+	case []byte:
+		return v, true
+	case string:
+		return []byte(v), true
+	}
+	return nil, false
+}
+
 // Float64Slice is a custom data type for a float64 slice.
 type Float64Slice []float64
 
@@ -18,7 +42,7 @@ func (f *Float64Slice) Scan(value interface{}) error {
         return nil
     }
 
-    byteValue, ok := value.([]byte)
+    byteValue, ok := jsonBytes(value)
     if !ok {
         return errors.New("Invalid Scan Source")
     }
@@ -32,14 +56,14 @@ func (f Float64Slice) Value() (driver.Value, error) {
         return nil, nil
     }
 
-    return json.Marshal(f)
+    return jsonValue(f)
 }
 
 type StringSlice []string
 
 func (s StringSlice) Value() (driver.Value, error) {
     // Serialize the StringSlice to a JSON string
-    return json.Marshal(s)
+    return jsonValue(s)
 }
 
 func (s *StringSlice) Scan(value interface{}) error {
@@ -47,7 +71,7 @@ func (s *StringSlice) Scan(value interface{}) error {
     if value == nil {
         return nil
     }
-    if str, ok := value.([]byte); ok {
+    if str, ok := jsonBytes(value); ok {
         return json.Unmarshal(str, s)
     }
     return errors.New("Invalid value type for StringSlice")
@@ -57,7 +81,7 @@ type Int64Slice []int64
 
 func (i Int64Slice) Value() (driver.Value, error) {
     // Serialize the Int64Slice to a JSON string
-    return json.Marshal(i)
+    return jsonValue(i)
 }
 // 
 func (i *Int64Slice) Scan(value interface{}) error {
@@ -65,7 +89,7 @@ func (i *Int64Slice) Scan(value interface{}) error {
     if value == nil {
         return nil
     }
-    if str, ok := value.([]byte); ok {
+    if str, ok := jsonBytes(value); ok {
         return json.Unmarshal(str, i)
     }
     return errors.New("Invalid value type for Int64Slice")
@@ -73,6 +97,9 @@ func (i *Int64Slice) Scan(value interface{}) error {
 
 type TradingSystem struct {
 	gorm.Model
+	// Revision is incremented by every write and lets clients detect that
+	// the row changed since they read it.
+	Revision                 uint `gorm:"not null;default:1"`
 	Symbol                   string
 	ClosingPrices            Float64Slice `gorm:"type:json"`
 	Timestamps               Int64Slice `gorm:"type:json"`
@@ -117,12 +144,24 @@ type TradingSystem struct {
 type DBServicer interface {
 	CreateTradingSystem(trade *TradingSystem) (tradeID uint, err error)
 	ReadTradingSystem(tradeID uint) (*TradingSystem, error)
+	ReadTradingSystemRevision(tradeID uint, revision uint) (*TradingSystem, error)
+	ReadTradingSystemAsOf(tradeID uint, asOf time.Time) (*TradingSystem, error)
+	ListTradingSystems(filter TradingSystemFilter) (trades []*TradingSystem, nextCursor string, err error)
 	UpdateTradingSystem(trade *TradingSystem) error
+	PatchTradingSystem(tradeID uint, patch []byte) (*TradingSystem, error)
+	AppendPrices(tradeID uint, points []PricePoint) (*TradingSystem, error)
+	ReadPrices(query PriceQuery) ([]PriceTick, error)
+	RevertTradingSystem(tradeID uint, revision uint) (*TradingSystem, error)
 	DeleteTradingSystem(tradeID uint) error
+	ListHistory(query HistoryQuery) (entries []*TradingSystemHistory, nextCursor string, err error)
+	// WithClient returns a DBServicer that records client as the origin
+	// of its writes in the history.
+	WithClient(client string) DBServicer
 }
 
 type TradingSystemData struct {
 	ID                       uint
+	Revision                 uint      `json:"revision"`
 	Symbol                   string    `json:"symbol"`
 	ClosingPrices            []float64   `json:"closing_prices"`
 	Timestamps               []int64     `json:"timestamps"`
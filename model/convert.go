@@ -0,0 +1,97 @@
+package model
+
+// NewTradingSystem converts the wire representation of a trading system into
+// its storage model. The ID and timestamps are left for the database to set;
+// the revision is kept as the one the client expects to overwrite.
+func NewTradingSystem(ts *TradingSystemData) *TradingSystem {
+	return &TradingSystem{
+		Revision:                 ts.Revision,
+		Symbol:                   ts.Symbol,
+		ClosingPrices:            Float64Slice(ts.ClosingPrices),
+		Timestamps:               Int64Slice(ts.Timestamps),
+		Signals:                  StringSlice(ts.Signals),
+		NextInvestBuYPrice:       Float64Slice(ts.NextInvestBuYPrice),
+		NextProfitSeLLPrice:      Float64Slice(ts.NextProfitSeLLPrice),
+		CommissionPercentage:     ts.CommissionPercentage,
+		InitialCapital:           ts.InitialCapital,
+		PositionSize:             ts.PositionSize,
+		EntryPrice:               Float64Slice(ts.EntryPrice),
+		InTrade:                  ts.InTrade,
+		QuoteBalance:             ts.QuoteBalance,
+		BaseBalance:              ts.BaseBalance,
+		RiskCost:                 ts.RiskCost,
+		DataPoint:                ts.DataPoint,
+		CurrentPrice:             ts.CurrentPrice,
+		EntryQuantity:            Float64Slice(ts.EntryQuantity),
+		EntryCostLoss:            Float64Slice(ts.EntryCostLoss),
+		TradeCount:               ts.TradeCount,
+		TradingLevel:             ts.TradingLevel,
+		ClosedWinTrades:          ts.ClosedWinTrades,
+		EnableStoploss:           ts.EnableStoploss,
+		StopLossTrigered:         ts.StopLossTrigered,
+		StopLossRecover:          Float64Slice(ts.StopLossRecover),
+		RiskFactor:               ts.RiskFactor,
+		MaxDataSize:              ts.MaxDataSize,
+		RiskProfitLossPercentage: ts.RiskProfitLossPercentage,
+		BaseCurrency:             ts.BaseCurrency,
+		QuoteCurrency:            ts.QuoteCurrency,
+		MiniQty:                  ts.MiniQty,
+		MaxQty:                   ts.MaxQty,
+		MinNotional:              ts.MinNotional,
+		StepSize:                 ts.StepSize,
+		TargetStopLoss:           ts.TargetStopLoss,
+		TargetProfit:             ts.TargetProfit,
+		TotalProfitLoss:          ts.TotalProfitLoss,
+		RiskPositionPercentage:   ts.RiskPositionPercentage,
+		ShortPeriod:              ts.ShortPeriod,
+		LongPeriod:               ts.LongPeriod,
+	}
+}
+
+// Data converts the storage model of a trading system into its wire
+// representation.
+func (t *TradingSystem) Data() *TradingSystemData {
+	return &TradingSystemData{
+		ID:                       t.ID,
+		Revision:                 t.Revision,
+		Symbol:                   t.Symbol,
+		ClosingPrices:            []float64(t.ClosingPrices),
+		Timestamps:               []int64(t.Timestamps),
+		Signals:                  []string(t.Signals),
+		NextInvestBuYPrice:       []float64(t.NextInvestBuYPrice),
+		NextProfitSeLLPrice:      []float64(t.NextProfitSeLLPrice),
+		CommissionPercentage:     t.CommissionPercentage,
+		InitialCapital:           t.InitialCapital,
+		PositionSize:             t.PositionSize,
+		EntryPrice:               []float64(t.EntryPrice),
+		InTrade:                  t.InTrade,
+		QuoteBalance:             t.QuoteBalance,
+		BaseBalance:              t.BaseBalance,
+		RiskCost:                 t.RiskCost,
+		DataPoint:                t.DataPoint,
+		CurrentPrice:             t.CurrentPrice,
+		EntryQuantity:            []float64(t.EntryQuantity),
+		EntryCostLoss:            []float64(t.EntryCostLoss),
+		TradeCount:               t.TradeCount,
+		TradingLevel:             t.TradingLevel,
+		ClosedWinTrades:          t.ClosedWinTrades,
+		EnableStoploss:           t.EnableStoploss,
+		StopLossTrigered:         t.StopLossTrigered,
+		StopLossRecover:          []float64(t.StopLossRecover),
+		RiskFactor:               t.RiskFactor,
+		MaxDataSize:              t.MaxDataSize,
+		RiskProfitLossPercentage: t.RiskProfitLossPercentage,
+		BaseCurrency:             t.BaseCurrency,
+		QuoteCurrency:            t.QuoteCurrency,
+		MiniQty:                  t.MiniQty,
+		MaxQty:                   t.MaxQty,
+		MinNotional:              t.MinNotional,
+		StepSize:                 t.StepSize,
+		TargetStopLoss:           t.TargetStopLoss,
+		TargetProfit:             t.TargetProfit,
+		TotalProfitLoss:          t.TotalProfitLoss,
+		RiskPositionPercentage:   t.RiskPositionPercentage,
+		ShortPeriod:              t.ShortPeriod,
+		LongPeriod:               t.LongPeriod,
+	}
+}
@@ -0,0 +1,88 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error codes reported to clients. They are part of the wire protocol, so
+// existing values must never change.
+const (
+	CodeNotFound       = "not_found"
+	CodeInvalidPayload = "invalid_payload"
+	CodeConflict       = "conflict"
+	CodeInternal       = "internal"
+	CodeUnknownAction  = "unknown_action"
+	CodeUnknownEntity  = "unknown_entity"
+	// CodeUnauthorized is reported for a missing, unknown or revoked API
+	// key, and CodeForbidden for a key that lacks the scope of an action.
+	CodeUnauthorized = "unauthorized"
+	CodeForbidden    = "forbidden"
+	// CodeUnavailable is reported for the messages received while the
+	// server shuts down; they were not applied and can be sent again.
+	CodeUnavailable = "unavailable"
+)
+
+// Error is the typed error returned by the DBServicer implementations. Code
+// is one of the Code* constants and Message is a human readable description
+// safe to send to clients.
+type Error struct {
+	Code    string
+	Message string
+	Err     error
+
+	// Revision is the current revision of the entity for CodeConflict.
+	Revision uint
+}
+
+// Errorf returns an *Error with the given code and a formatted message.
+func Errorf(code string, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// ConflictError reports a write against a stale revision of a trading system.
+func ConflictError(tradeID, expected, current uint) *Error {
+	return &Error{
+		Code:     CodeConflict,
+		Message:  fmt.Sprintf("TradingSystem with ID %d is at revision %d, not %d", tradeID, current, expected),
+		Revision: current,
+	}
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+// Unwrap returns the underlying error, if any.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ErrorCode returns the code of err. Errors that are not an *Error are
+// reported as CodeInternal; a nil error has no code.
+func ErrorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return CodeInternal
+}
+
+// ErrorMessage returns the client facing message of err.
+func ErrorMessage(err error) string {
+	if err == nil {
+		return ""
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Message
+	}
+	return err.Error()
+}
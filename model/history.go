@@ -0,0 +1,160 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// History actions, named after the operation that produced the revision.
+const (
+	HistoryCreate      = "create"
+	HistoryUpdate      = "update"
+	HistoryPatch       = "patch"
+	HistoryAppendPrice = "append-price"
+	HistoryDelete      = "delete"
+	HistoryRestore     = "restore"
+	HistoryRevert      = "revert"
+)
+
+// FieldChanges is a list of field changes stored as a JSON column.
+type FieldChanges []FieldChange
+
+// Value converts FieldChanges to a database value.
+func (c FieldChanges) Value() (driver.Value, error) {
+	if c == nil {
+		c = FieldChanges{}
+	}
+	return jsonValue(c)
+}
+
+// Scan scans a value into FieldChanges.
+func (c *FieldChanges) Scan(value interface{}) error {
+	if value == nil {
+		*c = nil
+		return nil
+	}
+	byteValue, ok := jsonBytes(value)
+	if !ok {
+		return errors.New("Invalid value type for FieldChanges")
+	}
+	return json.Unmarshal(byteValue, c)
+}
+
+// TradingSystemHistory is one entry of the append-only audit trail of a
+// trading system: the revision a write produced, who made it and the fields
+// it changed with their old and new values. Entries are never updated or
+// deleted, and outlive the trading system itself.
+type TradingSystemHistory struct {
+	ID              uint         `gorm:"primary_key" json:"id"`
+	TradingSystemID uint         `gorm:"not null;unique_index:idx_history_trading_system_revision" json:"trading_system_id"`
+	Revision        uint         `gorm:"not null;unique_index:idx_history_trading_system_revision" json:"revision"`
+	Action          string       `gorm:"not null" json:"action"`
+	Client          string       `json:"client"`
+	Changes         FieldChanges `gorm:"type:json" json:"changes"`
+	CreatedAt       time.Time    `json:"created_at"`
+}
+
+// NewHistory returns the history entry recording that client brought trade to
+// its current revision through action.
+func NewHistory(action, client string, trade *TradingSystem, changes []FieldChange) *TradingSystemHistory {
+	return &TradingSystemHistory{
+		TradingSystemID: trade.ID,
+		Revision:        trade.Revision,
+		Action:          action,
+		Client:          client,
+		Changes:         changes,
+	}
+}
+
+// Default and maximum number of entries returned by one history page.
+const (
+	DefaultHistoryLimit = 100
+	MaxHistoryLimit     = 1000
+)
+
+// HistoryQuery selects one page of the history of a trading system, in
+// ascending revision order. Cursor is the next_cursor of the previous page.
+type HistoryQuery struct {
+	ID     uint   `json:"id"`
+	Limit  int    `json:"limit,omitempty"`
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// Normalize validates q and fills in the defaults.
+func (q *HistoryQuery) Normalize() error {
+	if q.ID == 0 {
+		return errors.New("id is required")
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultHistoryLimit
+	} else if q.Limit > MaxHistoryLimit {
+		q.Limit = MaxHistoryLimit
+	}
+	if _, err := q.AfterRevision(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// AfterRevision returns the revision the page starts after.
+func (q *HistoryQuery) AfterRevision() (uint, error) {
+	if q.Cursor == "" {
+		return 0, nil
+	}
+	rev, err := strconv.ParseUint(q.Cursor, 10, 64)
+	if err != nil {
+		return 0, errors.New("invalid cursor")
+	}
+	return uint(rev), nil
+}
+
+// HistoryCursor returns the cursor of the page following entry.
+func HistoryCursor(entry *TradingSystemHistory) string {
+	return strconv.FormatUint(uint64(entry.Revision), 10)
+}
+
+// Rewind returns t as it was at the revision before the oldest of entries, by
+// undoing the changes of entries from the newest to the oldest. entries must
+// be the consecutive history entries following that revision, up to the
+// revision of t.
+func (t *TradingSystem) Rewind(entries []*TradingSystemHistory) (*TradingSystem, error) {
+	doc := make(map[string]json.RawMessage)
+	b, err := json.Marshal(t.Data())
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		for _, c := range entries[i].Changes {
+			var old json.RawMessage
+			if c.Appended != nil {
+				old, err = c.undoAppend(doc[c.Field])
+			} else {
+				old, err = json.Marshal(c.Old)
+			}
+			if err != nil {
+				return nil, err
+			}
+			doc[c.Field] = old
+		}
+	}
+	if b, err = json.Marshal(doc); err != nil {
+		return nil, err
+	}
+	var ts TradingSystemData
+	if err := json.Unmarshal(b, &ts); err != nil {
+		return nil, err
+	}
+	rewound := NewTradingSystem(&ts)
+	rewound.Model = t.Model
+	rewound.Revision = t.Revision
+	if len(entries) > 0 {
+		rewound.Revision = entries[0].Revision - 1
+	}
+	return rewound, nil
+}
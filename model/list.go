@@ -0,0 +1,131 @@
+package model
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Page sizes of ListTradingSystems.
+const (
+	DefaultListLimit = 100
+	MaxListLimit     = 1000
+)
+
+// TradingSystemFilter selects, orders and pages the trading systems returned
+// by ListTradingSystems. Nil fields do not filter. SortBy takes the JSON name
+// of any scalar field (default "id") and Order is "asc" (default) or "desc";
+// ties are broken by ID. Cursor is the NextCursor of the previous page.
+type TradingSystemFilter struct {
+	Symbol           *string    `json:"symbol"`
+	BaseCurrency     *string    `json:"base_currency"`
+	QuoteCurrency    *string    `json:"quote_currency"`
+	InTrade          *bool      `json:"in_trade"`
+	StopLossTrigered *bool      `json:"stop_loss_triggered"`
+	CreatedAfter     *time.Time `json:"created_after"`
+	CreatedBefore    *time.Time `json:"created_before"`
+	UpdatedAfter     *time.Time `json:"updated_after"`
+	UpdatedBefore    *time.Time `json:"updated_before"`
+	SortBy           string     `json:"sort_by"`
+	Order            string     `json:"order"`
+	Limit            int        `json:"limit"`
+	Cursor           string     `json:"cursor"`
+}
+
+// SortField describes a column trading systems can be ordered by.
+type SortField struct {
+	Name   string // JSON name used by clients
+	Field  string // TradingSystem struct field
+	Column string // database column
+}
+
+// sortFields holds every scalar field of TradingSystemData, keyed by JSON name.
+var sortFields = func() map[string]SortField {
+	fields := map[string]SortField{
+		"id":         {Name: "id", Field: "ID", Column: "id"},
+		"created_at": {Name: "created_at", Field: "CreatedAt", Column: "created_at"},
+		"updated_at": {Name: "updated_at", Field: "UpdatedAt", Column: "updated_at"},
+	}
+	t := reflect.TypeOf(TradingSystemData{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" || f.Type.Kind() == reflect.Slice {
+			continue
+		}
+		fields[name] = SortField{Name: name, Field: f.Name, Column: gorm.ToColumnName(f.Name)}
+	}
+	return fields
+}()
+
+// Normalize validates f and fills in the defaults.
+func (f *TradingSystemFilter) Normalize() error {
+	if f.SortBy == "" {
+		f.SortBy = "id"
+	}
+	if _, ok := sortFields[f.SortBy]; !ok {
+		return fmt.Errorf("cannot sort by %q", f.SortBy)
+	}
+	switch f.Order {
+	case "":
+		f.Order = "asc"
+	case "asc", "desc":
+	default:
+		return fmt.Errorf("order must be asc or desc, not %q", f.Order)
+	}
+	if f.Limit <= 0 {
+		f.Limit = DefaultListLimit
+	} else if f.Limit > MaxListLimit {
+		f.Limit = MaxListLimit
+	}
+	return nil
+}
+
+// SortField returns the field f.SortBy refers to. Call Normalize first.
+func (f *TradingSystemFilter) SortField() SortField {
+	return sortFields[f.SortBy]
+}
+
+// listCursor is the position after which the next page starts: the sort
+// value and ID of the last trading system of the previous page.
+type listCursor struct {
+	Value json.RawMessage `json:"v"`
+	ID    uint            `json:"id"`
+}
+
+// SortValue returns the value of the sort field of t.
+func (f *TradingSystemFilter) SortValue(t *TradingSystem) interface{} {
+	return reflect.ValueOf(t).Elem().FieldByName(f.SortField().Field).Interface()
+}
+
+// EncodeCursor returns the cursor of the page following t.
+func (f *TradingSystemFilter) EncodeCursor(t *TradingSystem) string {
+	v, _ := json.Marshal(f.SortValue(t))
+	c, _ := json.Marshal(listCursor{Value: v, ID: t.ID})
+	return base64.RawURLEncoding.EncodeToString(c)
+}
+
+// DecodeCursor returns the sort value, typed like the sort field, and the ID
+// stored in f.Cursor.
+func (f *TradingSystemFilter) DecodeCursor() (value interface{}, id uint, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(f.Cursor)
+	if err != nil {
+		return nil, 0, errors.New("invalid cursor")
+	}
+	var c listCursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, 0, errors.New("invalid cursor")
+	}
+	field, _ := reflect.TypeOf(TradingSystem{}).FieldByName(f.SortField().Field)
+	v := reflect.New(field.Type)
+	if err := json.Unmarshal(c.Value, v.Interface()); err != nil {
+		return nil, 0, errors.New("cursor does not match sort_by")
+	}
+	return v.Elem().Interface(), c.ID, nil
+}
@@ -0,0 +1,224 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Validate checks the fields every stored trading system must satisfy.
+func (ts *TradingSystemData) Validate() error {
+	if ts.Symbol == "" {
+		return errors.New("symbol is required")
+	}
+	if ts.MaxDataSize < 0 || ts.DataPoint < 0 || ts.TradeCount < 0 || ts.ClosedWinTrades < 0 {
+		return errors.New("max_data_size, data_point, trade_count and closed_win_trades must not be negative")
+	}
+	if ts.ShortPeriod < 0 || ts.LongPeriod < 0 {
+		return errors.New("short_period and long_period must not be negative")
+	}
+	return nil
+}
+
+// MergePatch applies the JSON merge patch (RFC 7396) patch to the JSON
+// document doc and returns the result.
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	var target, p interface{}
+	if len(bytes.TrimSpace(doc)) > 0 {
+		if err := json.Unmarshal(doc, &target); err != nil {
+			return nil, err
+		}
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, err
+	}
+	return json.Marshal(mergePatch(target, p))
+}
+
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		// Anything but an object replaces the target as a whole.
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+	return t
+}
+
+// ApplyPatch returns a copy of t with the JSON merge patch applied to its
+// TradingSystemData representation. Fields the patch sets to null are reset
+// to their zero value; the ID cannot be changed. A revision in the patch is
+// the revision the client expects t to have and is left in the result.
+func (t *TradingSystem) ApplyPatch(patch []byte) (*TradingSystem, error) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &obj); err != nil || obj == nil {
+		return nil, errors.New("patch must be a JSON object")
+	}
+	doc, err := json.Marshal(t.Data())
+	if err != nil {
+		return nil, err
+	}
+	merged, err := MergePatch(doc, patch)
+	if err != nil {
+		return nil, err
+	}
+	var ts TradingSystemData
+	dec := json.NewDecoder(bytes.NewReader(merged))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&ts); err != nil {
+		return nil, err
+	}
+	if ts.ID != t.ID {
+		return nil, errors.New("ID cannot be patched")
+	}
+	patched := NewTradingSystem(&ts)
+	patched.Model = t.Model
+	return patched, nil
+}
+
+// FieldChange is the change of one field between two versions of a trading
+// system. Field is the JSON name and Column the database column. An append to
+// an array field is recorded as a delta instead of the old and new arrays:
+// Appended holds the entries added at its end and Dropped those trimmed from
+// its front, if any.
+type FieldChange struct {
+	Field    string      `json:"field"`
+	Column   string      `json:"-"`
+	Old      interface{} `json:"old,omitempty"`
+	New      interface{} `json:"new,omitempty"`
+	Appended interface{} `json:"appended,omitempty"`
+	Dropped  interface{} `json:"dropped,omitempty"`
+}
+
+// appendChange returns the delta recording that added was appended to the
+// field named field and dropped trimmed from its front.
+func appendChange(field string, added, dropped interface{}) FieldChange {
+	c := FieldChange{Appended: added}
+	for _, f := range dataFields {
+		if f.field == field {
+			c.Field, c.Column = f.name, f.column
+		}
+	}
+	if reflect.ValueOf(dropped).Len() > 0 {
+		c.Dropped = dropped
+	}
+	return c
+}
+
+// undoAppend returns the value the field of c had before the append c
+// records, given its value after it.
+func (c FieldChange) undoAppend(after json.RawMessage) (json.RawMessage, error) {
+	var entries, added, dropped []json.RawMessage
+	if len(after) > 0 {
+		if err := json.Unmarshal(after, &entries); err != nil {
+			return nil, err
+		}
+	}
+	for _, v := range []struct {
+		value interface{}
+		into  *[]json.RawMessage
+	}{{c.Appended, &added}, {c.Dropped, &dropped}} {
+		if v.value == nil {
+			continue
+		}
+		b, err := json.Marshal(v.value)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, v.into); err != nil {
+			return nil, err
+		}
+	}
+	// Before trimming the field was the old entries followed by the added ones
+	entries = append(dropped, entries...)
+	if len(added) > len(entries) {
+		return nil, fmt.Errorf("%s holds fewer entries than were appended", c.Field)
+	}
+	return json.Marshal(entries[:len(entries)-len(added)])
+}
+
+// dataField maps a TradingSystem field onto its JSON name and column.
+type dataField struct {
+	field  string
+	name   string
+	column string
+}
+
+// dataFields lists every data field shared by TradingSystem and
+// TradingSystemData. The ID and the revision identify a version rather than
+// describe it, so they are left out.
+var dataFields = func() []dataField {
+	var fields []dataField
+	t := reflect.TypeOf(TradingSystemData{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Name == "ID" || f.Name == "Revision" {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, dataField{field: f.Name, name: name, column: gorm.ToColumnName(f.Name)})
+	}
+	return fields
+}()
+
+// Diff returns the fields whose value differs between old and new. Values are
+// compared by their JSON encoding, so a nil slice equals an empty one.
+func Diff(old, new *TradingSystem) []FieldChange {
+	var changes []FieldChange
+	ov, nv := reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem()
+	for _, f := range dataFields {
+		o, n := ov.FieldByName(f.field).Interface(), nv.FieldByName(f.field).Interface()
+		if jsonEqual(o, n) {
+			continue
+		}
+		changes = append(changes, FieldChange{Field: f.name, Column: f.column, Old: o, New: n})
+	}
+	return changes
+}
+
+func jsonEqual(a, b interface{}) bool {
+	ab, aerr := json.Marshal(a)
+	bb, berr := json.Marshal(b)
+	if aerr != nil || berr != nil {
+		return reflect.DeepEqual(a, b)
+	}
+	return bytes.Equal(normalizeEmpty(ab), normalizeEmpty(bb))
+}
+
+func normalizeEmpty(b []byte) []byte {
+	if string(b) == "null" {
+		return []byte("[]")
+	}
+	return b
+}
+
+// Columns returns the changes as a column to new value map, ready to be
+// passed to an UPDATE. Appends carry no new value and are left out.
+func Columns(changes []FieldChange) map[string]interface{} {
+	cols := make(map[string]interface{}, len(changes))
+	for _, c := range changes {
+		if c.Appended != nil {
+			continue
+		}
+		cols[c.Column] = c.New
+	}
+	return cols
+}
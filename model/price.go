@@ -0,0 +1,110 @@
+package model
+
+import "errors"
+
+// PricePoint is one tick appended to the price history of a trading system.
+type PricePoint struct {
+	Timestamp int64   `json:"timestamp"`
+	Price     float64 `json:"price"`
+	Signal    string  `json:"signal"`
+}
+
+// AppendPrices adds points to the ClosingPrices, Timestamps and Signals of t
+// and drops the oldest entries beyond MaxDataSize, when it is set. It returns
+// the changes as deltas, see FieldChange.
+func (t *TradingSystem) AppendPrices(points []PricePoint) []FieldChange {
+	prices := make(Float64Slice, len(points))
+	timestamps := make(Int64Slice, len(points))
+	signals := make(StringSlice, len(points))
+	for i, p := range points {
+		prices[i], timestamps[i], signals[i] = p.Price, p.Timestamp, p.Signal
+	}
+	var droppedPrices Float64Slice
+	var droppedTimestamps Int64Slice
+	var droppedSignals StringSlice
+	t.ClosingPrices, droppedPrices = appendTrimmed(t.ClosingPrices, prices, t.MaxDataSize)
+	t.Timestamps, droppedTimestamps = appendTrimmed(t.Timestamps, timestamps, t.MaxDataSize)
+	t.Signals, droppedSignals = appendTrimmed(t.Signals, signals, t.MaxDataSize)
+	return []FieldChange{
+		appendChange("ClosingPrices", prices, droppedPrices),
+		appendChange("Timestamps", timestamps, droppedTimestamps),
+		appendChange("Signals", signals, droppedSignals),
+	}
+}
+
+// appendTrimmed returns s followed by added, without its first entries beyond
+// max when max is set, and the entries it dropped. The result shares no
+// memory with s.
+func appendTrimmed[S ~[]E, E any](s, added S, max int) (S, S) {
+	all := make(S, 0, len(s)+len(added))
+	all = append(append(all, s...), added...)
+	if max <= 0 || len(all) <= max {
+		return all, nil
+	}
+	n := len(all) - max
+	return all[n:], all[:n:n]
+}
+
+// PriceTick is one row of the normalized price history. Ticks are keyed by
+// symbol and timestamp, independently of the trading systems that produced
+// them, so the history of a symbol can be queried by time range.
+type PriceTick struct {
+	ID        uint    `gorm:"primary_key" json:"-"`
+	Symbol    string  `gorm:"not null;unique_index:idx_price_ticks_symbol_timestamp" json:"symbol"`
+	Timestamp int64   `gorm:"not null;unique_index:idx_price_ticks_symbol_timestamp" json:"timestamp"`
+	Price     float64 `json:"price"`
+	Signal    string  `json:"signal"`
+}
+
+// Result sizes of ReadPrices.
+const (
+	DefaultPriceLimit = 1000
+	MaxPriceLimit     = 10000
+)
+
+// PriceQuery selects the ticks of one symbol returned by ReadPrices, in
+// ascending timestamp order. From and To bound the timestamps inclusively and
+// are ignored when zero. A non-zero Interval downsamples the result to the
+// last tick of every Interval-wide bucket; it is in the unit of the
+// timestamps.
+type PriceQuery struct {
+	Symbol   string `json:"symbol"`
+	From     int64  `json:"from"`
+	To       int64  `json:"to"`
+	Limit    int    `json:"limit"`
+	Interval int64  `json:"interval"`
+}
+
+// Normalize validates q and fills in the defaults.
+func (q *PriceQuery) Normalize() error {
+	if q.Symbol == "" {
+		return errors.New("symbol is required")
+	}
+	if q.Interval < 0 {
+		return errors.New("interval must not be negative")
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultPriceLimit
+	} else if q.Limit > MaxPriceLimit {
+		q.Limit = MaxPriceLimit
+	}
+	return nil
+}
+
+// PriceTicks returns the price history of t as ticks. Timestamps and prices
+// are paired by position; signals are optional.
+func (t *TradingSystem) PriceTicks() []PriceTick {
+	n := len(t.ClosingPrices)
+	if len(t.Timestamps) < n {
+		n = len(t.Timestamps)
+	}
+	ticks := make([]PriceTick, 0, n)
+	for i := 0; i < n; i++ {
+		tick := PriceTick{Symbol: t.Symbol, Timestamp: t.Timestamps[i], Price: t.ClosingPrices[i]}
+		if i < len(t.Signals) {
+			tick.Signal = t.Signals[i]
+		}
+		ticks = append(ticks, tick)
+	}
+	return ticks
+}
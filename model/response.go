@@ -0,0 +1,55 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Response statuses.
+const (
+	StatusOK    = "ok"
+	StatusError = "error"
+)
+
+// Response is the envelope of every reply of the service, on the WebSocket
+// and over REST. Status is StatusOK or StatusError; on error, Error carries
+// one of the Code* constants so clients can branch on the failure kind.
+type Response struct {
+	RequestID string `json:"request_id,omitempty"`
+	Status    string `json:"status"`
+	Message   string `json:"message,omitempty"`
+	DataID    uint   `json:"data_id,omitempty"`
+	// Revision is the revision of the trading system after a write.
+	Revision uint        `json:"revision,omitempty"`
+	Data     interface{} `json:"data,omitempty"`
+	// NextCursor is set on list responses that have a following page.
+	NextCursor string         `json:"next_cursor,omitempty"`
+	Error      *ResponseError `json:"error,omitempty"`
+}
+
+// ResponseError describes a failed request. CurrentRevision is set on
+// conflict errors so the client can re-read and retry.
+type ResponseError struct {
+	Code            string `json:"code"`
+	Message         string `json:"message"`
+	CurrentRevision uint   `json:"current_revision,omitempty"`
+}
+
+// Error implements the error interface, so clients can return a failed
+// response as an error.
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// NewResponseError builds the error part of a response from err.
+func NewResponseError(err error) *ResponseError {
+	re := &ResponseError{
+		Code:    ErrorCode(err),
+		Message: ErrorMessage(err),
+	}
+	var e *Error
+	if errors.As(err, &e) && e.Code == CodeConflict {
+		re.CurrentRevision = e.Revision
+	}
+	return re
+}
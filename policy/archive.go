@@ -0,0 +1,203 @@
+package policy
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	dbgorm "github.com/chidi150c/database/gorm"
+	"github.com/chidi150c/database/model"
+	"github.com/jinzhu/gorm"
+)
+
+// Archiver writes trading systems to dated, gzip compressed NDJSON files, one
+// TradingSystemData JSON object per line, so that data pruned by the
+// retention policy stays available for post-trade analysis.
+type Archiver struct {
+	Dir string
+	now func() time.Time
+}
+
+// NewArchiver returns an Archiver writing into dir.
+func NewArchiver(dir string) *Archiver {
+	return &Archiver{Dir: dir, now: time.Now}
+}
+
+// Archive writes trades to a new archive file and returns its path. The file
+// only appears under its final name once it is completely written.
+func (a *Archiver) Archive(trades []*model.TradingSystem) (string, error) {
+	if err := os.MkdirAll(a.Dir, 0o755); err != nil {
+		return "", fmt.Errorf("Error creating archive directory: %v", err)
+	}
+	path, err := a.nextPath()
+	if err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(a.Dir, ".archive-*")
+	if err != nil {
+		return "", fmt.Errorf("Error creating archive file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	zw := gzip.NewWriter(tmp)
+	enc := json.NewEncoder(zw)
+	for _, t := range trades {
+		if err := enc.Encode(t.Data()); err != nil {
+			return "", fmt.Errorf("Error writing archive: %v", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return "", fmt.Errorf("Error writing archive: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return "", fmt.Errorf("Error writing archive: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("Error writing archive: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("Error naming archive: %v", err)
+	}
+	return path, nil
+}
+
+// nextPath returns an unused file name for an archive written now.
+func (a *Archiver) nextPath() (string, error) {
+	base := "trading-systems-" + a.now().UTC().Format("2006-01-02-150405")
+	for i := 0; i < 1000; i++ {
+		name := base + ".ndjson.gz"
+		if i > 0 {
+			name = fmt.Sprintf("%s-%d.ndjson.gz", base, i)
+		}
+		path := filepath.Join(a.Dir, name)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no free archive file name for %s", base)
+}
+
+// RestoreReport lists what RestoreArchive did with every archived record.
+type RestoreReport struct {
+	// Restored holds the IDs re-imported, either as new rows or by undoing
+	// their soft deletion.
+	Restored []uint
+	// Skipped holds the IDs left alone because a live row has the same ID.
+	Skipped []uint
+}
+
+// RestoreArchive re-imports the trading systems of an archive file written by
+// Archiver, keeping their original IDs. Records whose ID is still in use by a
+// live trading system are skipped. The file is imported in one transaction.
+func RestoreArchive(dbs *dbgorm.DBServices, path string) (*RestoreReport, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening archive: %v", err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading archive: %v", err)
+	}
+	defer zr.Close()
+
+	report := &RestoreReport{}
+	tx := dbs.DB.Begin()
+	if tx.Error != nil {
+		return nil, fmt.Errorf("Error starting restore: %v", tx.Error)
+	}
+	scanner := bufio.NewScanner(zr)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var ts model.TradingSystemData
+		if err := json.Unmarshal(scanner.Bytes(), &ts); err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("Error reading archive line %d: %v", line, err)
+		}
+		restored, err := restoreTradingSystem(tx, &ts)
+		if err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("Error restoring TradingSystem with ID %d: %v", ts.ID, err)
+		}
+		if restored {
+			report.Restored = append(report.Restored, ts.ID)
+		} else {
+			report.Skipped = append(report.Skipped, ts.ID)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("Error reading archive: %v", err)
+	}
+	// PostgreSQL does not move the ID sequence past explicitly inserted IDs
+	if dbs.Driver() == dbgorm.DriverPostgres && len(report.Restored) > 0 {
+		err := tx.Exec("SELECT setval(pg_get_serial_sequence('trading_systems', 'id'), (SELECT MAX(id) FROM trading_systems))").Error
+		if err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("Error resetting TradingSystem ID sequence: %v", err)
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("Error committing restore: %v", err)
+	}
+	return report, nil
+}
+
+// RestoreClient is recorded as the origin of restored trading systems in the
+// history.
+const RestoreClient = "restore-archive"
+
+// restoreTradingSystem writes ts back under its ID unless a live row uses it.
+// The restored state gets a revision past every revision the trading system
+// ever had, so its history carries on where it left off, and its price ticks
+// missing from price_ticks are recorded.
+func restoreTradingSystem(tx *gorm.DB, ts *model.TradingSystemData) (bool, error) {
+	trade := model.NewTradingSystem(ts)
+	trade.ID = ts.ID
+	existing := new(model.TradingSystem)
+	err := tx.Unscoped().First(existing, ts.ID).Error
+	switch {
+	case gorm.IsRecordNotFoundError(err):
+		existing = &model.TradingSystem{}
+	case err != nil:
+		return false, err
+	case existing.DeletedAt == nil:
+		return false, nil
+	}
+	var last struct{ Revision uint }
+	err = tx.Model(&model.TradingSystemHistory{}).
+		Select("MAX(revision) AS revision").
+		Where("trading_system_id = ?", ts.ID).
+		Scan(&last).Error
+	if err != nil {
+		return false, err
+	}
+	if existing.Revision > last.Revision {
+		last.Revision = existing.Revision
+	}
+	if trade.Revision > last.Revision {
+		last.Revision = trade.Revision
+	}
+	trade.Revision = last.Revision + 1
+
+	if existing.ID == 0 {
+		err = tx.Create(trade).Error
+	} else {
+		// Undo the soft deletion and bring back the archived state
+		trade.CreatedAt = existing.CreatedAt
+		err = tx.Unscoped().Save(trade).Error
+	}
+	if err != nil {
+		return false, err
+	}
+	entry := model.NewHistory(model.HistoryRestore, RestoreClient, trade, model.Diff(existing, trade))
+	if err := tx.Create(entry).Error; err != nil {
+		return false, err
+	}
+	return true, dbgorm.SyncPriceTicks(tx, trade)
+}
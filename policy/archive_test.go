@@ -0,0 +1,201 @@
+package policy_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/chidi150c/database/dbtest"
+	dbgorm "github.com/chidi150c/database/gorm"
+	"github.com/chidi150c/database/model"
+	"github.com/chidi150c/database/policy"
+)
+
+// archiveFixture creates three BTC trading systems with prices and has the
+// retention archive and soft-delete the two oldest. It returns the trading
+// systems as they were and the archive file.
+func archiveFixture(t *testing.T) (*dbgorm.DBServices, []*model.TradingSystem, string) {
+	t.Helper()
+	dbs := openTestDB(t)
+	var trades []*model.TradingSystem
+	for i := 0; i < 3; i++ {
+		trade := model.NewTradingSystem(&model.TradingSystemData{
+			Symbol:        "BTC",
+			ClosingPrices: []float64{float64(i), float64(i) + 0.5},
+			Timestamps:    []int64{10, 20},
+			Signals:       []string{"buy", "sell"},
+			QuoteBalance:  float64(100 * i),
+		})
+		if _, err := dbs.CreateTradingSystem(trade); err != nil {
+			t.Fatalf("CreateTradingSystem: %v", err)
+		}
+		trades = append(trades, trade)
+	}
+	config := policy.Config{Rules: []policy.Rule{{Kind: policy.KeepLatestPerSymbol, Keep: 1}}, ArchiveDir: t.TempDir()}
+	report, err := policy.NewRetention(dbs, config).Enforce()
+	if err != nil {
+		t.Fatalf("Enforce: %v", err)
+	}
+	if len(report.Rules) != 1 || len(report.Rules[0].IDs) != 2 || report.Rules[0].Archive == "" {
+		t.Fatalf("Enforce reported %+v, want 2 trading systems archived", report.Rules)
+	}
+	return dbs, trades, report.Rules[0].Archive
+}
+
+// sameData reports whether a and b hold the same data, whatever their revision.
+func sameData(a, b *model.TradingSystem) bool {
+	ad, bd := a.Data(), b.Data()
+	ad.Revision, bd.Revision = 0, 0
+	return reflect.DeepEqual(ad, bd)
+}
+
+func TestArchiveRestore(t *testing.T) {
+	dbs, trades, archive := archiveFixture(t)
+	// The oldest is purged for good, the other one is only soft-deleted
+	if err := dbs.DB.Unscoped().Delete(&model.TradingSystem{}, trades[0].ID).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := policy.RestoreArchive(dbs, archive)
+	if err != nil {
+		t.Fatalf("RestoreArchive: %v", err)
+	}
+	if want := []uint{trades[0].ID, trades[1].ID}; !reflect.DeepEqual(sortedIDs(report.Restored), want) || len(report.Skipped) != 0 {
+		t.Fatalf("RestoreArchive reported %+v, want %v restored", report, want)
+	}
+	for _, want := range trades[:2] {
+		got, err := dbs.ReadTradingSystem(want.ID)
+		if err != nil {
+			t.Fatalf("ReadTradingSystem(%d): %v", want.ID, err)
+		}
+		if !sameData(got, want) {
+			t.Fatalf("restored %+v, want %+v", got.Data(), want.Data())
+		}
+		// The history carries on past the deletion
+		entries, _, err := dbs.ListHistory(model.HistoryQuery{ID: want.ID})
+		if err != nil || len(entries) != 3 {
+			t.Fatalf("ListHistory(%d) = %v, %v, want create, delete and restore", want.ID, entries, err)
+		}
+		last := entries[len(entries)-1]
+		if last.Action != model.HistoryRestore || last.Client != policy.RestoreClient || last.Revision != 3 || got.Revision != 3 {
+			t.Fatalf("restore recorded as %+v at revision %d, want a restore at revision 3", last, got.Revision)
+		}
+	}
+
+	// Restoring again finds them live
+	report, err = policy.RestoreArchive(dbs, archive)
+	if err != nil {
+		t.Fatalf("RestoreArchive again: %v", err)
+	}
+	if len(report.Restored) != 0 || len(report.Skipped) != 2 {
+		t.Fatalf("RestoreArchive again reported %+v, want both skipped", report)
+	}
+}
+
+func TestArchiveRestoreSkipsLiveIDs(t *testing.T) {
+	dbs, trades, _ := archiveFixture(t)
+	// An archive of the live trading system, which has changed since
+	live := *trades[2]
+	live.QuoteBalance = 1
+	archive, err := policy.NewArchiver(t.TempDir()).Archive([]*model.TradingSystem{&live, trades[1]})
+	if err != nil {
+		t.Fatalf("Archive: %v", err)
+	}
+
+	report, err := policy.RestoreArchive(dbs, archive)
+	if err != nil {
+		t.Fatalf("RestoreArchive: %v", err)
+	}
+	if !reflect.DeepEqual(report.Skipped, []uint{live.ID}) || !reflect.DeepEqual(report.Restored, []uint{trades[1].ID}) {
+		t.Fatalf("RestoreArchive reported %+v, want %d skipped and %d restored", report, live.ID, trades[1].ID)
+	}
+	got, err := dbs.ReadTradingSystem(live.ID)
+	if err != nil {
+		t.Fatalf("ReadTradingSystem: %v", err)
+	}
+	if !sameData(got, trades[2]) || got.Revision != 1 {
+		t.Fatalf("live trading system became %+v, want it untouched", got.Data())
+	}
+}
+
+// TestArchiveRestoreIntoEmptyDatabase restores an archive into a database that
+// never held its trading systems: new ones must still get an unused ID.
+func TestArchiveRestoreIntoEmptyDatabase(t *testing.T) {
+	_, trades, archive := archiveFixture(t)
+	for name, open := range map[string]func(t *testing.T) *dbgorm.DBServices{
+		"SQLite": openTestDB,
+		"Postgres": func(t *testing.T) *dbgorm.DBServices {
+			return openDB(t, dbgorm.DriverPostgres, dbtest.PostgresDSN(t))
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dbs := open(t)
+			report, err := policy.RestoreArchive(dbs, archive)
+			if want := []uint{trades[0].ID, trades[1].ID}; err != nil || !reflect.DeepEqual(sortedIDs(report.Restored), want) {
+				t.Fatalf("RestoreArchive = %+v, %v, want %v restored", report, err, want)
+			}
+			for _, want := range trades[:2] {
+				got, err := dbs.ReadTradingSystem(want.ID)
+				if err != nil || !sameData(got, want) {
+					t.Fatalf("ReadTradingSystem(%d) = %+v, %v, want %+v", want.ID, got, err, want.Data())
+				}
+			}
+			// The price history comes back with them
+			ticks, err := dbs.ReadPrices(model.PriceQuery{Symbol: "BTC"})
+			want := []model.PriceTick{{Symbol: "BTC", Timestamp: 10, Price: 0, Signal: "buy"}, {Symbol: "BTC", Timestamp: 20, Price: 0.5, Signal: "sell"}}
+			for i := range ticks {
+				ticks[i].ID = 0
+			}
+			if err != nil || !reflect.DeepEqual(ticks, want) {
+				t.Fatalf("ReadPrices = %+v, %v, want %+v", ticks, err, want)
+			}
+			id, err := dbs.CreateTradingSystem(&model.TradingSystem{Symbol: "ETH"})
+			if err != nil {
+				t.Fatalf("CreateTradingSystem after a restore: %v", err)
+			}
+			if id <= trades[1].ID {
+				t.Fatalf("CreateTradingSystem after a restore got ID %d, want one past %d", id, trades[1].ID)
+			}
+		})
+	}
+}
+
+func TestArchiveRestoreInvalidFile(t *testing.T) {
+	dbs, trades, archive := archiveFixture(t)
+	valid, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var malformed bytes.Buffer
+	zw := gzip.NewWriter(&malformed)
+	zw.Write([]byte(`{"ID": 1, "Symbol": "BTC"}` + "\n" + `{"ID": 2, "Symbol":` + "\n"))
+	zw.Close()
+
+	for name, content := range map[string][]byte{
+		"not gzip":       []byte(`{"ID": 1, "Symbol": "BTC"}`),
+		"truncated gzip": valid[:len(valid)-10],
+		"malformed JSON": malformed.Bytes(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "archive.ndjson.gz")
+			if err := os.WriteFile(path, content, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if report, err := policy.RestoreArchive(dbs, path); err == nil {
+				t.Fatalf("RestoreArchive succeeded with %+v", report)
+			}
+			// Nothing read before the error is kept
+			for _, trade := range trades[:2] {
+				if _, err := dbs.ReadTradingSystem(trade.ID); model.ErrorCode(err) != model.CodeNotFound {
+					t.Fatalf("ReadTradingSystem(%d) = %v, want the trading system still deleted", trade.ID, err)
+				}
+			}
+		})
+	}
+	if _, err := policy.RestoreArchive(dbs, filepath.Join(t.TempDir(), "missing.ndjson.gz")); err == nil {
+		t.Fatal("RestoreArchive of a missing file succeeded")
+	}
+}
@@ -0,0 +1,282 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chidi150c/database/gorm"
+	"github.com/chidi150c/database/model"
+)
+
+// RuleKind is the kind of a retention rule.
+type RuleKind string
+
+// Retention rule kinds.
+const (
+	// KeepLatestPerSymbol soft-deletes all but the Keep most recently created
+	// trading systems of every symbol.
+	KeepLatestPerSymbol RuleKind = "keep_latest_per_symbol"
+	// MaxAge soft-deletes trading systems not updated for longer than Age.
+	MaxAge RuleKind = "max_age"
+	// PurgeDeleted permanently removes trading systems soft-deleted more
+	// than Age ago.
+	PurgeDeleted RuleKind = "purge_deleted"
+)
+
+// Rule is one retention rule.
+type Rule struct {
+	Kind RuleKind
+	Keep int
+	Age  time.Duration
+}
+
+func (r Rule) String() string {
+	if r.Kind == KeepLatestPerSymbol {
+		return fmt.Sprintf("%s=%d", r.Kind, r.Keep)
+	}
+	return fmt.Sprintf("%s=%s", r.Kind, r.Age)
+}
+
+// DefaultRules keeps the 500 latest trading systems of every symbol.
+var DefaultRules = []Rule{{Kind: KeepLatestPerSymbol, Keep: 500}}
+
+// ParseRules parses a comma separated list of kind=value rules, for example
+// "keep_latest_per_symbol=500,max_age=720h,purge_deleted=168h".
+func ParseRules(s string) ([]Rule, error) {
+	var rules []Rule
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kind, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("retention rule %q is not kind=value", part)
+		}
+		rule := Rule{Kind: RuleKind(kind)}
+		switch rule.Kind {
+		case KeepLatestPerSymbol:
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("retention rule %q needs a non-negative count", part)
+			}
+			rule.Keep = n
+		case MaxAge, PurgeDeleted:
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("retention rule %q needs a positive duration", part)
+			}
+			rule.Age = d
+		default:
+			return nil, fmt.Errorf("unknown retention rule %q", kind)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Config configures the retention engine.
+type Config struct {
+	Rules []Rule
+	// DryRun only reports what the rules would remove.
+	DryRun bool
+	// ArchiveDir, when set, is where the trading systems are archived
+	// before being removed.
+	ArchiveDir string
+}
+
+// RuleReport lists the trading systems a rule removed, or would remove.
+type RuleReport struct {
+	Rule Rule
+	IDs  []uint
+	// Archive is the file the removed trading systems were archived to.
+	Archive string
+	// Err is why the rule did not complete. The following rules still ran.
+	Err error
+}
+
+// Report is the outcome of one retention run.
+type Report struct {
+	DryRun bool
+	Rules  []RuleReport
+}
+
+func (r *Report) String() string {
+	verb := "removed"
+	if r.DryRun {
+		verb = "would remove"
+	}
+	var b strings.Builder
+	for i, rr := range r.Rules {
+		if i > 0 {
+			b.WriteString("; ")
+		}
+		fmt.Fprintf(&b, "%s %s %d records %v", rr.Rule, verb, len(rr.IDs), rr.IDs)
+		if rr.Archive != "" {
+			fmt.Fprintf(&b, " archived to %s", rr.Archive)
+		}
+		if rr.Err != nil {
+			fmt.Fprintf(&b, " failed: %v", rr.Err)
+		}
+	}
+	return b.String()
+}
+
+// Retention applies retention rules to the trading systems.
+type Retention struct {
+	dbs *gorm.DBServices
+	// deleter records the soft deletions as made by RetentionClient.
+	deleter  *gorm.DBServices
+	config   Config
+	archiver *Archiver
+	now      func() time.Time
+}
+
+// NewRetention returns a retention engine for dbs.
+func NewRetention(dbs *gorm.DBServices, config Config) *Retention {
+	r := &Retention{
+		dbs:     dbs,
+		deleter: dbs.WithClient(RetentionClient).(*gorm.DBServices),
+		config:  config,
+		now:     time.Now,
+	}
+	if config.ArchiveDir != "" {
+		r.archiver = NewArchiver(config.ArchiveDir)
+	}
+	return r
+}
+
+// deleteBatch bounds the number of IDs bound in a single DELETE statement.
+const deleteBatch = 500
+
+// Enforce applies every rule in order and reports what was removed. A rule
+// that fails is reported with its error and the next rules still run; the
+// returned error then joins the errors of every failed rule. The database is
+// vacuumed once rows were purged.
+func (r *Retention) Enforce() (*Report, error) {
+	report := &Report{DryRun: r.config.DryRun}
+	var errs []error
+	purged := false
+	for _, rule := range r.config.Rules {
+		rr := r.enforce(rule)
+		if rr.Err != nil {
+			errs = append(errs, fmt.Errorf("retention rule %s: %w", rule, rr.Err))
+		}
+		if rule.Kind == PurgeDeleted && !r.config.DryRun && len(rr.IDs) > 0 {
+			purged = true
+		}
+		report.Rules = append(report.Rules, rr)
+	}
+	// Give the space of the purged rows back
+	if purged {
+		if err := r.dbs.Vacuum(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return report, errors.Join(errs...)
+}
+
+// enforce applies rule and reports what it removed.
+func (r *Retention) enforce(rule Rule) RuleReport {
+	rr := RuleReport{Rule: rule}
+	rr.IDs, rr.Err = r.selectIDs(rule)
+	if rr.Err != nil || r.config.DryRun || len(rr.IDs) == 0 {
+		return rr
+	}
+	// Nothing is removed unless it was archived first
+	if r.archiver != nil {
+		if rr.Archive, rr.Err = r.archive(rr.IDs); rr.Err != nil {
+			return rr
+		}
+	}
+	rr.Err = r.remove(rule, rr.IDs)
+	return rr
+}
+
+// selectIDs returns the IDs of the trading systems rule applies to.
+func (r *Retention) selectIDs(rule Rule) ([]uint, error) {
+	db := r.dbs.DB.Model(&model.TradingSystem{})
+	var ids []uint
+	switch rule.Kind {
+	case KeepLatestPerSymbol:
+		var symbols []string
+		if err := db.Pluck("DISTINCT symbol", &symbols).Error; err != nil {
+			return nil, err
+		}
+		for _, symbol := range symbols {
+			var symbolIDs []uint
+			err := r.dbs.DB.Model(&model.TradingSystem{}).
+				Where("symbol = ?", symbol).
+				Order("id DESC").
+				Pluck("id", &symbolIDs).Error
+			if err != nil {
+				return nil, err
+			}
+			if len(symbolIDs) > rule.Keep {
+				ids = append(ids, symbolIDs[rule.Keep:]...)
+			}
+		}
+	case MaxAge:
+		err := db.Where("updated_at < ?", gorm.StorageTime(r.now().Add(-rule.Age))).Order("id ASC").Pluck("id", &ids).Error
+		if err != nil {
+			return nil, err
+		}
+	case PurgeDeleted:
+		err := db.Unscoped().
+			Where("deleted_at IS NOT NULL AND deleted_at < ?", gorm.StorageTime(r.now().Add(-rule.Age))).
+			Order("id ASC").
+			Pluck("id", &ids).Error
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown rule kind %q", rule.Kind)
+	}
+	return ids, nil
+}
+
+// archive writes the trading systems ids to a new archive file.
+func (r *Retention) archive(ids []uint) (string, error) {
+	var trades []*model.TradingSystem
+	for start := 0; start < len(ids); start += deleteBatch {
+		end := start + deleteBatch
+		if end > len(ids) {
+			end = len(ids)
+		}
+		var batch []*model.TradingSystem
+		if err := r.dbs.DB.Unscoped().Where("id IN (?)", ids[start:end]).Order("id ASC").Find(&batch).Error; err != nil {
+			return "", err
+		}
+		trades = append(trades, batch...)
+	}
+	return r.archiver.Archive(trades)
+}
+
+// RetentionClient is recorded as the origin of the deletions made by the retention
+// policy in the trading system history.
+const RetentionClient = "retention"
+
+// remove soft-deletes ids, or deletes them for good for PurgeDeleted. IDs are
+// bound explicitly since GORM ignores Limit on Delete.
+func (r *Retention) remove(rule Rule, ids []uint) error {
+	for start := 0; start < len(ids); start += deleteBatch {
+		end := start + deleteBatch
+		if end > len(ids) {
+			end = len(ids)
+		}
+		if rule.Kind == PurgeDeleted {
+			// The history of purged trading systems is kept
+			if err := r.dbs.DB.Unscoped().Where("id IN (?)", ids[start:end]).Delete(&model.TradingSystem{}).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		if err := r.deleter.DeleteTradingSystems(ids[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,344 @@
+package policy_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chidi150c/database/dbtest"
+	dbgorm "github.com/chidi150c/database/gorm"
+	"github.com/chidi150c/database/model"
+	"github.com/chidi150c/database/policy"
+	"github.com/jinzhu/gorm"
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	dbtest.StopPostgres()
+	os.Exit(code)
+}
+
+// openTestDB opens and migrates a SQLite database that is closed with the
+// test.
+func openTestDB(t *testing.T) *dbgorm.DBServices {
+	t.Helper()
+	return openDB(t, dbgorm.DriverSQLite, filepath.Join(t.TempDir(), "test.db"))
+}
+
+// openDB opens and migrates the database dsn, closed with the test.
+func openDB(t *testing.T, driver, dsn string) *dbgorm.DBServices {
+	t.Helper()
+	dbs, err := dbgorm.Open(driver, dsn)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { dbs.Close() })
+	if err := dbs.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	return dbs
+}
+
+// createTradingSystems creates a trading system of every symbol, in order.
+func createTradingSystems(t *testing.T, dbs *dbgorm.DBServices, symbols ...string) []uint {
+	t.Helper()
+	var ids []uint
+	for _, symbol := range symbols {
+		id, err := dbs.CreateTradingSystem(model.NewTradingSystem(&model.TradingSystemData{Symbol: symbol}))
+		if err != nil {
+			t.Fatalf("CreateTradingSystem: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestRetentionConflict(t *testing.T) {
+	dbs := openTestDB(t)
+	ids := createTradingSystems(t, dbs, "BTC", "BTC")
+
+	// Another writer updates the trading system between the moment the
+	// retention reads it and the moment it deletes it. The write is made in
+	// the transaction of the retention, the only connection of SQLite.
+	written := false
+	dbs.DB.Callback().Query().After("gorm:query").Register("test:concurrent_write", func(scope *gorm.Scope) {
+		if written || scope.TableName() != "trading_systems" {
+			return
+		}
+		written = true
+		scope.NewDB().Exec("UPDATE trading_systems SET revision = revision + 1 WHERE id = ?", ids[0])
+	})
+	r := policy.NewRetention(dbs, policy.Config{Rules: []policy.Rule{{Kind: policy.KeepLatestPerSymbol, Keep: 1}}})
+	_, err := r.Enforce()
+	if code := model.ErrorCode(err); code != model.CodeConflict {
+		t.Fatalf("Enforce returned %v with code %q, want a conflict", err, code)
+	}
+	// The whole batch is rolled back
+	for _, id := range ids {
+		if _, err := dbs.ReadTradingSystem(id); err != nil {
+			t.Fatalf("ReadTradingSystem(%d) after the conflict: %v", id, err)
+		}
+	}
+}
+
+func TestRetentionRuleErrors(t *testing.T) {
+	dbs := openTestDB(t)
+	ids := createTradingSystems(t, dbs, "BTC", "BTC")
+	rules := []policy.Rule{{Kind: "unknown"}, {Kind: policy.KeepLatestPerSymbol, Keep: 1}}
+	report, err := policy.NewRetention(dbs, policy.Config{Rules: rules}).Enforce()
+	if err == nil || !strings.Contains(err.Error(), "unknown") {
+		t.Fatalf("Enforce returned %v, want the error of the unknown rule", err)
+	}
+	// The rule after the failed one still ran
+	if len(report.Rules) != 2 || report.Rules[0].Err == nil || report.Rules[1].Err != nil || !reflect.DeepEqual(report.Rules[1].IDs, ids[:1]) {
+		t.Fatalf("Enforce reported %+v, want the first rule failed and %d removed", report.Rules, ids[0])
+	}
+	if _, err := dbs.ReadTradingSystem(ids[0]); model.ErrorCode(err) != model.CodeNotFound {
+		t.Fatalf("ReadTradingSystem(%d) = %v, want it deleted", ids[0], err)
+	}
+	if s := report.String(); !strings.Contains(s, "failed") {
+		t.Fatalf("report %q does not mention the failed rule", s)
+	}
+}
+
+// TestRetentionStorageZone runs the age rules against timestamps GORM writes
+// in another zone than the local one, which SQLite compares as text.
+func TestRetentionStorageZone(t *testing.T) {
+	_, offset := time.Now().Zone()
+	zone := time.FixedZone("", offset-10*3600)
+	nowFunc := gorm.NowFunc
+	gorm.NowFunc = func() time.Time { return time.Now().In(zone) }
+	t.Cleanup(func() { gorm.NowFunc = nowFunc })
+
+	dbs := openTestDB(t)
+	ids := createTradingSystems(t, dbs, "BTC", "ETH")
+	if err := dbs.DeleteTradingSystem(ids[1]); err != nil {
+		t.Fatalf("DeleteTradingSystem: %v", err)
+	}
+	rules := []policy.Rule{{Kind: policy.MaxAge, Age: time.Hour}, {Kind: policy.PurgeDeleted, Age: time.Hour}}
+	report, err := policy.NewRetention(dbs, policy.Config{Rules: rules, DryRun: true}).Enforce()
+	if err != nil {
+		t.Fatalf("Enforce: %v", err)
+	}
+	for _, rr := range report.Rules {
+		if len(rr.IDs) != 0 {
+			t.Fatalf("%s selected %v written just now", rr.Rule, rr.IDs)
+		}
+	}
+}
+
+func TestRetentionVacuum(t *testing.T) {
+	dbs := openTestDB(t)
+	prices := make([]float64, 2000)
+	for i := 0; i < 100; i++ {
+		trade := model.NewTradingSystem(&model.TradingSystemData{Symbol: "BTC", ClosingPrices: prices})
+		if _, err := dbs.CreateTradingSystem(trade); err != nil {
+			t.Fatalf("CreateTradingSystem: %v", err)
+		}
+	}
+	if err := dbs.DB.Exec("UPDATE trading_systems SET deleted_at = ?", time.Now().Add(-48*time.Hour)).Error; err != nil {
+		t.Fatal(err)
+	}
+	// The pages of the database, wherever the journal holds them
+	size := func() int64 {
+		t.Helper()
+		var pages int64
+		if err := dbs.DB.Raw("PRAGMA page_count").Row().Scan(&pages); err != nil {
+			t.Fatal(err)
+		}
+		return pages
+	}
+	before := size()
+	rules := []policy.Rule{{Kind: policy.PurgeDeleted, Age: 24 * time.Hour}}
+	if _, err := policy.NewRetention(dbs, policy.Config{Rules: rules}).Enforce(); err != nil {
+		t.Fatalf("Enforce: %v", err)
+	}
+	if after := size(); after >= before {
+		t.Fatalf("the database has %d pages after the purge, %d before", after, before)
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []policy.Rule
+		wantErr bool
+	}{
+		{in: "", want: nil},
+		{in: "keep_latest_per_symbol=500", want: []policy.Rule{{Kind: policy.KeepLatestPerSymbol, Keep: 500}}},
+		{in: "keep_latest_per_symbol=0", want: []policy.Rule{{Kind: policy.KeepLatestPerSymbol}}},
+		{
+			in: "keep_latest_per_symbol=500,max_age=720h,purge_deleted=168h",
+			want: []policy.Rule{
+				{Kind: policy.KeepLatestPerSymbol, Keep: 500},
+				{Kind: policy.MaxAge, Age: 720 * time.Hour},
+				{Kind: policy.PurgeDeleted, Age: 168 * time.Hour},
+			},
+		},
+		{in: " max_age=90m , ", want: []policy.Rule{{Kind: policy.MaxAge, Age: 90 * time.Minute}}},
+		{in: "keep_latest_per_symbol", wantErr: true},
+		{in: "keep_latest_per_symbol=-1", wantErr: true},
+		{in: "keep_latest_per_symbol=many", wantErr: true},
+		{in: "max_age=0s", wantErr: true},
+		{in: "max_age=-1h", wantErr: true},
+		{in: "purge_deleted=soon", wantErr: true},
+		{in: "keep_forever=1", wantErr: true},
+		{in: "max_age=1h,nope", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := policy.ParseRules(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseRules(%q) = %v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseRules(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+// retentionFixture creates BTC trading systems 0 to 2 and ETH ones 3 and 4,
+// 3 last updated two days ago and 4 deleted two days ago.
+func retentionFixture(t *testing.T) (*dbgorm.DBServices, []uint) {
+	t.Helper()
+	dbs := openTestDB(t)
+	ids := createTradingSystems(t, dbs, "BTC", "BTC", "BTC", "ETH", "ETH")
+	twoDaysAgo := time.Now().Add(-48 * time.Hour)
+	if err := dbs.DB.Exec("UPDATE trading_systems SET updated_at = ? WHERE id = ?", twoDaysAgo, ids[3]).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := dbs.DeleteTradingSystem(ids[4]); err != nil {
+		t.Fatalf("DeleteTradingSystem: %v", err)
+	}
+	if err := dbs.DB.Exec("UPDATE trading_systems SET deleted_at = ? WHERE id = ?", twoDaysAgo, ids[4]).Error; err != nil {
+		t.Fatal(err)
+	}
+	return dbs, ids
+}
+
+func sortedIDs(ids []uint) []uint {
+	sorted := append([]uint(nil), ids...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+func TestRetentionRules(t *testing.T) {
+	tests := []struct {
+		rule policy.Rule
+		// want are the indexes of the fixture trading systems removed
+		want []int
+	}{
+		{policy.Rule{Kind: policy.KeepLatestPerSymbol, Keep: 2}, []int{0}},
+		{policy.Rule{Kind: policy.KeepLatestPerSymbol, Keep: 1}, []int{0, 1}},
+		{policy.Rule{Kind: policy.KeepLatestPerSymbol, Keep: 0}, []int{0, 1, 2, 3}},
+		{policy.Rule{Kind: policy.MaxAge, Age: 24 * time.Hour}, []int{3}},
+		{policy.Rule{Kind: policy.MaxAge, Age: 72 * time.Hour}, nil},
+		{policy.Rule{Kind: policy.PurgeDeleted, Age: 24 * time.Hour}, []int{4}},
+		{policy.Rule{Kind: policy.PurgeDeleted, Age: 72 * time.Hour}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule.String(), func(t *testing.T) {
+			dbs, ids := retentionFixture(t)
+			report, err := policy.NewRetention(dbs, policy.Config{Rules: []policy.Rule{tt.rule}}).Enforce()
+			if err != nil {
+				t.Fatalf("Enforce: %v", err)
+			}
+			var want []uint
+			removed := make(map[uint]bool)
+			for _, i := range tt.want {
+				want = append(want, ids[i])
+				removed[ids[i]] = true
+			}
+			if len(report.Rules) != 1 || !reflect.DeepEqual(sortedIDs(report.Rules[0].IDs), want) {
+				t.Fatalf("Enforce reported %+v, want %v removed", report.Rules, want)
+			}
+
+			for i, id := range ids {
+				var count int
+				if err := dbs.DB.Unscoped().Model(&model.TradingSystem{}).Where("id = ?", id).Count(&count).Error; err != nil {
+					t.Fatal(err)
+				}
+				_, err := dbs.ReadTradingSystem(id)
+				live := err == nil
+				switch {
+				case tt.rule.Kind == policy.PurgeDeleted && removed[id]:
+					if count != 0 {
+						t.Errorf("trading system %d was not purged", i)
+					}
+				case removed[id]:
+					if live || count != 1 {
+						t.Errorf("trading system %d was not soft-deleted", i)
+					}
+					entries, _, err := dbs.ListHistory(model.HistoryQuery{ID: id, Cursor: "1"})
+					if err != nil || len(entries) != 1 || entries[0].Action != model.HistoryDelete || entries[0].Client != policy.RetentionClient {
+						t.Errorf("history of trading system %d is %v, %v, want a deletion by the retention", i, entries, err)
+					}
+				case i == 4:
+					if live || count != 1 {
+						t.Errorf("deleted trading system %d was restored or purged", i)
+					}
+				default:
+					if !live {
+						t.Errorf("trading system %d was removed: %v", i, err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRetentionDryRun(t *testing.T) {
+	dbs, ids := retentionFixture(t)
+	snapshot := func() ([]*model.TradingSystem, []*model.TradingSystemHistory) {
+		t.Helper()
+		var trades []*model.TradingSystem
+		var history []*model.TradingSystemHistory
+		if err := dbs.DB.Unscoped().Order("id ASC").Find(&trades).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := dbs.DB.Order("id ASC").Find(&history).Error; err != nil {
+			t.Fatal(err)
+		}
+		return trades, history
+	}
+	trades, history := snapshot()
+
+	rules, err := policy.ParseRules("keep_latest_per_symbol=1,max_age=24h,purge_deleted=24h")
+	if err != nil {
+		t.Fatalf("ParseRules: %v", err)
+	}
+	dir := t.TempDir()
+	report, err := policy.NewRetention(dbs, policy.Config{Rules: rules, DryRun: true, ArchiveDir: dir}).Enforce()
+	if err != nil {
+		t.Fatalf("Enforce: %v", err)
+	}
+	if !report.DryRun || len(report.Rules) != 3 {
+		t.Fatalf("Enforce reported %+v, want the 3 rules of a dry run", report)
+	}
+	// The rules see the same rows, nothing being removed in between
+	for i, want := range [][]uint{{ids[0], ids[1]}, {ids[3]}, {ids[4]}} {
+		if got := sortedIDs(report.Rules[i].IDs); !reflect.DeepEqual(got, want) || report.Rules[i].Archive != "" {
+			t.Errorf("rule %s reported %v archived to %q, want %v and no archive", report.Rules[i].Rule, got, report.Rules[i].Archive, want)
+		}
+	}
+	if !strings.Contains(report.String(), "would remove") {
+		t.Errorf("report %q does not say it is a dry run", report)
+	}
+
+	gotTrades, gotHistory := snapshot()
+	if !reflect.DeepEqual(gotTrades, trades) {
+		t.Errorf("dry run changed the trading systems")
+	}
+	if !reflect.DeepEqual(gotHistory, history) {
+		t.Errorf("dry run changed the history")
+	}
+	if files, err := os.ReadDir(dir); err != nil || len(files) != 0 {
+		t.Errorf("dry run wrote %v to the archive directory, %v", files, err)
+	}
+}
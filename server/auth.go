@@ -0,0 +1,253 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/chidi150c/database/model"
+)
+
+// actionScopes maps every WebSocket action to the API key scope it needs.
+var actionScopes = map[string]string{
+	"create":       model.ScopeWrite,
+	"read":         model.ScopeRead,
+	"list":         model.ScopeRead,
+	"update":       model.ScopeWrite,
+	"patch":        model.ScopeWrite,
+	"append-price": model.ScopeWrite,
+	"delete":       model.ScopeDelete,
+	"subscribe":    model.ScopeRead,
+	"unsubscribe":  model.ScopeRead,
+	"history":      model.ScopeRead,
+	"revert":       model.ScopeWrite,
+	"read-prices":  model.ScopeRead,
+	"create-key":   model.ScopeAdmin,
+	"list-keys":    model.ScopeAdmin,
+	"revoke-key":   model.ScopeAdmin,
+}
+
+// methodScopes maps the HTTP methods of the REST routes to the API key scope
+// they need.
+var methodScopes = map[string]string{
+	http.MethodGet:    model.ScopeRead,
+	http.MethodPost:   model.ScopeWrite,
+	http.MethodPut:    model.ScopeWrite,
+	http.MethodPatch:  model.ScopeWrite,
+	http.MethodDelete: model.ScopeDelete,
+}
+
+// CreateKeyRequest is the payload of the create-key action.
+type CreateKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// CreateKeyResponse is the data of the create-key response. Token is the
+// only copy of the key the server ever returns.
+type CreateKeyResponse struct {
+	Key   *model.APIKey `json:"key"`
+	Token string        `json:"token"`
+}
+
+// apiToken returns the API key presented with r: a bearer token in the
+// Authorization header, the X-API-Key header, or the api_key query
+// parameter for browsers, which cannot set headers on a WebSocket upgrade.
+func apiToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	if token := r.Header.Get("X-API-Key"); token != "" {
+		return token
+	}
+	return r.URL.Query().Get("api_key")
+}
+
+// principal is the authenticated client of a request: an API key, or a
+// verified TLS client certificate when no key is presented.
+type principal struct {
+	// name identifies the principal in the history and in errors, e.g.
+	// "key:bot" or "cert:bot-1".
+	name   string
+	scopes []string
+	// key is the API key of the principal, nil for a certificate.
+	key *model.APIKey
+}
+
+// authRequired reports whether every request must be authenticated, which
+// is the case once API keys or client certificate scopes are configured.
+func (th *TradeHandler) authRequired() bool {
+	return th.Keys != nil || th.CertScopes != nil
+}
+
+// authenticate returns the principal of r, nil when it presents no
+// credentials and authentication is not required.
+func (th *TradeHandler) authenticate(r *http.Request) (*principal, error) {
+	if token := apiToken(r); token != "" && th.Keys != nil {
+		key, err := th.Keys.FindAPIKey(model.HashAPIKey(token))
+		if err != nil {
+			if model.ErrorCode(err) == model.CodeNotFound {
+				return nil, model.Errorf(model.CodeUnauthorized, "Invalid API key")
+			}
+			return nil, err
+		}
+		if key.Revoked() {
+			return nil, model.Errorf(model.CodeUnauthorized, "API key %s was revoked", key.Prefix)
+		}
+		return &principal{name: "key:" + key.Name, scopes: key.Scopes, key: key}, nil
+	}
+	if cert := clientCertificate(r); cert != nil {
+		id := CertIdentity(cert)
+		scopes, ok := th.CertScopes[id]
+		if !ok {
+			scopes = th.CertScopes["*"]
+		}
+		return &principal{name: "cert:" + id, scopes: scopes}, nil
+	}
+	if th.authRequired() {
+		return nil, model.Errorf(model.CodeUnauthorized, "API key or client certificate required")
+	}
+	return nil, nil
+}
+
+// authorize checks that p is granted scope. Nothing is checked while
+// authentication is not required.
+func (th *TradeHandler) authorize(p *principal, scope string) error {
+	if !th.authRequired() {
+		return nil
+	}
+	if p == nil || !model.HasScope(p.scopes, scope) {
+		name := "Client"
+		if p != nil {
+			name = p.name
+		}
+		return model.Errorf(model.CodeForbidden, "%s lacks the %s scope", name, scope)
+	}
+	return nil
+}
+
+// clientName identifies a connection in the history, prefixed with the name
+// of its principal.
+func clientName(conn string, p *principal) string {
+	if p == nil {
+		return conn
+	}
+	return p.name + "/" + conn
+}
+
+type principalContextKey struct{}
+
+// requireKey is the middleware of the REST routes: it authenticates every
+// request and checks the scope of its method.
+func (th *TradeHandler) requireKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := th.authenticate(r)
+		if err == nil {
+			scope, ok := methodScopes[r.Method]
+			if !ok {
+				scope = model.ScopeAdmin
+			}
+			err = th.authorize(p, scope)
+		}
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalContextKey{}, p)))
+	})
+}
+
+// requestPrincipal returns the principal requireKey found for r.
+func requestPrincipal(r *http.Request) *principal {
+	p, _ := r.Context().Value(principalContextKey{}).(*principal)
+	return p
+}
+
+// processKeyMessage handles the actions of the api-key entity.
+func (th *TradeHandler) processKeyMessage(conn *wsConn, message WebSocketMessage) {
+	if th.Keys == nil {
+		writeError(model.Errorf(model.CodeUnknownAction, "API keys are disabled"), message.RequestID, conn)
+		return
+	}
+	switch message.Action {
+	case "create-key":
+		var req CreateKeyRequest
+		if err := decodeData(message.Data, &req); err != nil {
+			writeError(err, message.RequestID, conn)
+			return
+		}
+		key, token, err := model.NewAPIKey(req.Name, req.Scopes)
+		if err != nil {
+			writeError(model.Errorf(model.CodeInvalidPayload, "%v", err), message.RequestID, conn)
+			return
+		}
+		if err := th.Keys.CreateAPIKey(key); err != nil {
+			writeError(err, message.RequestID, conn)
+			return
+		}
+		log.Printf("API key %d (%s) created by %s", key.ID, key.Name, conn.client)
+		writeResponse(&model.Response{
+			RequestID: message.RequestID,
+			Status:    model.StatusOK,
+			Message:   "API key created successfully",
+			DataID:    key.ID,
+			Data:      &CreateKeyResponse{Key: key, Token: token},
+		}, conn)
+	case "list-keys":
+		keys, err := th.Keys.ListAPIKeys()
+		if err != nil {
+			writeError(err, message.RequestID, conn)
+			return
+		}
+		writeResponseWithData("API keys Listed successfully", keys, message.RequestID, conn)
+	case "revoke-key":
+		var req struct {
+			ID uint `json:"id"`
+		}
+		if err := decodeData(message.Data, &req); err != nil {
+			writeError(err, message.RequestID, conn)
+			return
+		}
+		key, err := th.Keys.RevokeAPIKey(req.ID)
+		if err != nil {
+			writeError(err, message.RequestID, conn)
+			return
+		}
+		log.Printf("API key %d (%s) revoked by %s", key.ID, key.Name, conn.client)
+		writeResponseWithID("API key revoked successfully", key.ID, message.RequestID, conn)
+		th.dropKey(key.ID, conn)
+	}
+}
+
+// dropKey cuts off the connections authenticated with a revoked key. The
+// connection that revoked it is kept open to receive the response, but its
+// later messages are refused.
+func (th *TradeHandler) dropKey(id uint, except *wsConn) {
+	th.connections.RLock()
+	defer th.connections.RUnlock()
+	for c := range th.connections.m {
+		if c.principal == nil || c.principal.key == nil || c.principal.key.ID != id {
+			continue
+		}
+		c.revoked.Store(true)
+		if c != except {
+			c.out.close()
+		}
+	}
+}
+
+// BootstrapAPIKey stores an admin key for token unless it already exists, so
+// that a new deployment has a key to create the others with.
+func BootstrapAPIKey(keys model.APIKeyServicer, token string) error {
+	if _, err := keys.FindAPIKey(model.HashAPIKey(token)); err == nil {
+		return nil
+	} else if model.ErrorCode(err) != model.CodeNotFound {
+		return err
+	}
+	key, err := model.NewAPIKeyFromToken("bootstrap", token, []string{model.ScopeAdmin})
+	if err != nil {
+		return err
+	}
+	return keys.CreateAPIKey(key)
+}
@@ -0,0 +1,194 @@
+package server_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chidi150c/database/client"
+	"github.com/chidi150c/database/model"
+	"github.com/chidi150c/database/server"
+	"github.com/chidi150c/database/servertest"
+	"github.com/gorilla/websocket"
+)
+
+const bootstrapToken = "bootstrap-token-for-tests"
+
+// newAuthServer starts a server that requires API keys, with an admin key
+// for bootstrapToken.
+func newAuthServer(t *testing.T) *servertest.Server {
+	t.Helper()
+	s := servertest.NewServer(t)
+	s.Handler.Keys = s.DBS
+	if err := server.BootstrapAPIKey(s.DBS, bootstrapToken); err != nil {
+		t.Fatalf("BootstrapAPIKey: %v", err)
+	}
+	return s
+}
+
+func dialKey(t *testing.T, s *servertest.Server, token string) *client.Client {
+	t.Helper()
+	c, err := client.Dial(context.Background(), client.Config{URL: s.WebSocketURL(), APIKey: token})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func upgradeStatus(t *testing.T, url string, header http.Header) int {
+	t.Helper()
+	conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+	if err == nil {
+		conn.Close()
+		return http.StatusSwitchingProtocols
+	}
+	if resp == nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	return resp.StatusCode
+}
+
+func TestAuthWebSocket(t *testing.T) {
+	ctx := context.Background()
+	s := newAuthServer(t)
+
+	if got := upgradeStatus(t, s.WebSocketURL(), nil); got != http.StatusUnauthorized {
+		t.Fatalf("upgrade without a key returned %d, want %d", got, http.StatusUnauthorized)
+	}
+	if got := upgradeStatus(t, s.WebSocketURL(), http.Header{"X-Api-Key": {"not-a-valid-key-at-all"}}); got != http.StatusUnauthorized {
+		t.Fatalf("upgrade with an unknown key returned %d, want %d", got, http.StatusUnauthorized)
+	}
+
+	admin := dialKey(t, s, bootstrapToken)
+	writerKey, writerToken, err := admin.CreateAPIKey(ctx, "bot", []string{model.ScopeRead, model.ScopeWrite})
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	if writerToken == "" || writerKey.Prefix != writerToken[:model.APIKeyPrefixLen] {
+		t.Fatalf("CreateAPIKey returned key %+v and token %q", writerKey, writerToken)
+	}
+	if _, _, err := admin.CreateAPIKey(ctx, "bad", []string{"superuser"}); client.ErrorCode(err) != model.CodeInvalidPayload {
+		t.Fatalf("CreateAPIKey with an unknown scope returned %v", err)
+	}
+
+	bot := dialKey(t, s, writerToken)
+	id, _, err := bot.CreateTradingSystem(ctx, &model.TradingSystemData{Symbol: "BTCUSDT"})
+	if err != nil {
+		t.Fatalf("CreateTradingSystem: %v", err)
+	}
+	if err := bot.Delete(ctx, id); client.ErrorCode(err) != model.CodeForbidden {
+		t.Fatalf("Delete without the delete scope returned %v, want %s", err, model.CodeForbidden)
+	}
+	if _, err := bot.ListAPIKeys(ctx); client.ErrorCode(err) != model.CodeForbidden {
+		t.Fatalf("ListAPIKeys without the admin scope returned %v, want %s", err, model.CodeForbidden)
+	}
+
+	// Writes are attributed to the key in the history.
+	entries, _, err := s.DBS.ListHistory(model.HistoryQuery{ID: id})
+	if err != nil || len(entries) != 1 || !strings.HasPrefix(entries[0].Client, "key:bot/ws:") {
+		t.Fatalf("ListHistory returned %+v, %v", entries, err)
+	}
+
+	keys, err := admin.ListAPIKeys(ctx)
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("ListAPIKeys returned %+v, %v", keys, err)
+	}
+	if err := admin.RevokeAPIKey(ctx, writerKey.ID); err != nil {
+		t.Fatalf("RevokeAPIKey: %v", err)
+	}
+	// The connections of the revoked key are closed and it cannot
+	// reconnect.
+	callCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	if _, err := bot.ReadTradingSystem(callCtx, id); err == nil {
+		t.Fatal("ReadTradingSystem with a revoked key succeeded")
+	}
+	if got := upgradeStatus(t, s.WebSocketURL(), http.Header{"Authorization": {"Bearer " + writerToken}}); got != http.StatusUnauthorized {
+		t.Fatalf("upgrade with a revoked key returned %d, want %d", got, http.StatusUnauthorized)
+	}
+}
+
+func TestAuthREST(t *testing.T) {
+	s := newAuthServer(t)
+	reader, readerToken, err := model.NewAPIKey("dashboard", []string{model.ScopeRead})
+	if err != nil {
+		t.Fatalf("NewAPIKey: %v", err)
+	}
+	if err := s.DBS.CreateAPIKey(reader); err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		header http.Header
+		want   int
+	}{
+		{"no key", http.MethodGet, "/trading-systems/", nil, http.StatusUnauthorized},
+		{"header key", http.MethodGet, "/trading-systems/", http.Header{"X-Api-Key": {readerToken}}, http.StatusOK},
+		{"query key", http.MethodGet, "/trading-systems/?api_key=" + readerToken, nil, http.StatusOK},
+		{"missing scope", http.MethodDelete, "/trading-systems/1", http.Header{"X-Api-Key": {readerToken}}, http.StatusForbidden},
+		{"stats", http.MethodGet, "/database-services/stats", http.Header{"Authorization": {"Bearer " + readerToken}}, http.StatusOK},
+		{"admin", http.MethodDelete, "/trading-systems/1", http.Header{"Authorization": {"Bearer " + bootstrapToken}}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, s.URL+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Fatalf("got status %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestOriginCheck(t *testing.T) {
+	s := servertest.NewServer(t)
+	origins, err := server.NewOriginPolicy([]string{"https://*.resoledge.com"})
+	if err != nil {
+		t.Fatalf("NewOriginPolicy: %v", err)
+	}
+	s.Handler.Origins = origins
+
+	tests := []struct {
+		origin string
+		want   int
+	}{
+		{"", http.StatusSwitchingProtocols},
+		{"https://app.resoledge.com", http.StatusSwitchingProtocols},
+		{"https://evil.example", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.origin != "" {
+			header.Set("Origin", tt.origin)
+		}
+		if got := upgradeStatus(t, s.WebSocketURL(), header); got != tt.want {
+			t.Errorf("upgrade from origin %q returned %d, want %d", tt.origin, got, tt.want)
+		}
+	}
+	req, _ := http.NewRequest(http.MethodGet, s.URL+"/trading-systems/", nil)
+	req.Header.Set("Origin", "https://evil.example")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("REST request from a foreign origin returned %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+}
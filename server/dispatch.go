@@ -0,0 +1,200 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/chidi150c/database/model"
+)
+
+// DefaultConcurrency is the number of WebSocket operations processed at once
+// when TradeHandler.Concurrency is not set.
+const DefaultConcurrency = 32
+
+// dispatcher runs operations in parallel, up to a concurrency limit, except
+// that operations on the same trading system run one at a time in the order
+// they were dispatched. An update sent right after another one on the same ID
+// is therefore never applied first.
+//
+// At most as many operations as the concurrency limit wait for a slot;
+// dispatch blocks beyond that, which stops reading from the connection until
+// the server catches up. A client cannot pile up queued operations or
+// goroutines faster than they are processed.
+type dispatcher struct {
+	sem chan struct{}
+
+	mu sync.Mutex
+	// room is signaled whenever a queued operation starts running.
+	room *sync.Cond
+	// pending holds the operations waiting behind the running one of each
+	// busy trading system.
+	pending map[uint][]func()
+	queued  int
+	running int
+	// idle holds the channels of wait, closed once nothing is queued or
+	// running.
+	idle []chan struct{}
+	// closed refuses new operations once the server shuts down.
+	closed bool
+}
+
+func newDispatcher(concurrency int) *dispatcher {
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	d := &dispatcher{
+		sem:     make(chan struct{}, concurrency),
+		pending: make(map[uint][]func()),
+	}
+	d.room = sync.NewCond(&d.mu)
+	return d
+}
+
+// dispatch schedules fn, waiting for room in the queue first, and reports
+// false if the dispatcher was closed instead. Operations with the same
+// non-zero key run in dispatch order; operations with key 0 are not
+// ordered.
+func (d *dispatcher) dispatch(key uint, fn func()) bool {
+	d.mu.Lock()
+	for d.queued >= cap(d.sem) && !d.closed {
+		d.room.Wait()
+	}
+	if d.closed {
+		d.mu.Unlock()
+		return false
+	}
+	d.queued++
+	if key == 0 {
+		d.mu.Unlock()
+		go d.run(fn)
+		return true
+	}
+	if q, busy := d.pending[key]; busy {
+		d.pending[key] = append(q, fn)
+		d.mu.Unlock()
+		return true
+	}
+	d.pending[key] = nil
+	d.mu.Unlock()
+	go d.drain(key, fn)
+	return true
+}
+
+// close refuses the operations dispatched from now on. The ones already
+// dispatched still run, so wait returns once they are done.
+func (d *dispatcher) close() {
+	d.mu.Lock()
+	d.closed = true
+	d.room.Broadcast()
+	d.mu.Unlock()
+}
+
+// drain runs fn and then the operations queued behind it on key.
+func (d *dispatcher) drain(key uint, fn func()) {
+	for fn != nil {
+		d.run(fn)
+		d.mu.Lock()
+		if q := d.pending[key]; len(q) > 0 {
+			fn = q[0]
+			d.pending[key] = q[1:]
+		} else {
+			delete(d.pending, key)
+			fn = nil
+		}
+		d.mu.Unlock()
+	}
+}
+
+// run waits for a free slot and runs fn in it.
+func (d *dispatcher) run(fn func()) {
+	d.sem <- struct{}{}
+	d.mu.Lock()
+	d.queued--
+	d.running++
+	d.room.Broadcast()
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		d.running--
+		if d.queued == 0 && d.running == 0 {
+			for _, ch := range d.idle {
+				close(ch)
+			}
+			d.idle = nil
+		}
+		d.mu.Unlock()
+		<-d.sem
+	}()
+	fn()
+}
+
+// wait blocks until no operation is queued or running, or until ctx is done.
+func (d *dispatcher) wait(ctx context.Context) error {
+	d.mu.Lock()
+	if d.queued == 0 && d.running == 0 {
+		d.mu.Unlock()
+		return nil
+	}
+	ch := make(chan struct{})
+	d.idle = append(d.idle, ch)
+	d.mu.Unlock()
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stats returns the number of operations waiting and running, and the
+// length of the longest queue of a single trading system.
+func (d *dispatcher) stats() (queued, running, longest int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, q := range d.pending {
+		if len(q) > longest {
+			longest = len(q)
+		}
+	}
+	return d.queued, d.running, longest
+}
+
+// dispatcher returns the dispatcher of th, creating it on first use with the
+// configured concurrency.
+func (th *TradeHandler) dispatcher() *dispatcher {
+	th.dispatchOnce.Do(func() {
+		th.dispatch = newDispatcher(th.Concurrency)
+	})
+	return th.dispatch
+}
+
+// serialize runs fn on the queue of the trading system tradeID and waits for
+// it, so that a REST write is ordered with the WebSocket operations on the
+// same trading system. It fails with CodeUnavailable once the server shuts
+// down.
+func (th *TradeHandler) serialize(tradeID uint, fn func() error) error {
+	done := make(chan error, 1)
+	if !th.dispatcher().dispatch(tradeID, func() { done <- fn() }) {
+		return model.Errorf(model.CodeUnavailable, "Server is shutting down")
+	}
+	return <-done
+}
+
+// messageKey returns the trading system ID a message applies to, or 0 if it
+// does not name one. Trading systems are sent with an "ID" field and the
+// other payloads with "id".
+func messageKey(msg WebSocketMessage) uint {
+	if actionEntities[msg.Action] != "trading-system" {
+		return 0
+	}
+	for k, v := range msg.Data {
+		if !strings.EqualFold(k, "id") {
+			continue
+		}
+		if id, ok := v.(float64); ok && id > 0 {
+			return uint(id)
+		}
+	}
+	return 0
+}
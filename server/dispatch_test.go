@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chidi150c/database/memory"
+	"github.com/chidi150c/database/model"
+)
+
+func TestDispatcherOrdersSameID(t *testing.T) {
+	d := newDispatcher(8)
+	var (
+		mu    sync.Mutex
+		order []int
+		wg    sync.WaitGroup
+	)
+	for i := 0; i < 100; i++ {
+		i := i
+		wg.Add(1)
+		d.dispatch(7, func() {
+			defer wg.Done()
+			// Let later operations overtake this one if they could.
+			time.Sleep(time.Duration(100-i) * time.Microsecond)
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+	for i, got := range order {
+		if got != i {
+			t.Fatalf("operation %d ran at position %d: %v", got, i, order)
+		}
+	}
+}
+
+func TestDispatcherLimitsConcurrency(t *testing.T) {
+	const limit = 3
+	d := newDispatcher(limit)
+	release := make(chan struct{})
+	started := make(chan struct{}, 10)
+	var wg sync.WaitGroup
+	// Operations on different IDs run in parallel up to the limit.
+	for id := uint(1); id <= 5; id++ {
+		wg.Add(1)
+		d.dispatch(id, func() {
+			defer wg.Done()
+			started <- struct{}{}
+			<-release
+		})
+	}
+	// An operation queued behind ID 1.
+	wg.Add(1)
+	d.dispatch(1, func() { wg.Done() })
+
+	for i := 0; i < limit; i++ {
+		<-started
+	}
+	select {
+	case <-started:
+		t.Fatalf("more than %d operations ran at once", limit)
+	case <-time.After(20 * time.Millisecond):
+	}
+	queued, running, longest := d.stats()
+	if queued != 3 || running != limit || longest != 1 {
+		t.Fatalf("got queued %d, running %d, longest %d, want 3, %d, 1", queued, running, longest, limit)
+	}
+
+	close(release)
+	wg.Wait()
+	if queued, running, longest := d.stats(); queued != 0 || running != 0 || longest != 0 {
+		t.Fatalf("got queued %d, running %d, longest %d after completion", queued, running, longest)
+	}
+}
+
+func TestDispatcherBackpressure(t *testing.T) {
+	const limit = 2
+	d := newDispatcher(limit)
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	// limit operations run and limit more wait: two behind ID 1 and the
+	// unordered ones waiting for a slot.
+	for _, key := range []uint{1, 0, 1, 1} {
+		wg.Add(1)
+		d.dispatch(key, func() {
+			defer wg.Done()
+			<-release
+		})
+	}
+	dispatched := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		d.dispatch(0, func() { wg.Done() })
+		close(dispatched)
+	}()
+	select {
+	case <-dispatched:
+		t.Fatal("dispatch did not wait for room in the queue")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if queued, running, _ := d.stats(); queued != limit || running != limit {
+		t.Fatalf("got queued %d, running %d, want %d, %d", queued, running, limit, limit)
+	}
+	close(release)
+	<-dispatched
+	wg.Wait()
+}
+
+func TestDispatcherWait(t *testing.T) {
+	d := newDispatcher(1)
+	if err := d.wait(context.Background()); err != nil {
+		t.Fatalf("wait on an idle dispatcher: %v", err)
+	}
+	release := make(chan struct{})
+	d.dispatch(1, func() { <-release })
+	d.dispatch(2, func() {})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := d.wait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("wait with operations running returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	close(release)
+	if err := d.wait(context.Background()); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+	if queued, running, _ := d.stats(); queued != 0 || running != 0 {
+		t.Fatalf("got queued %d, running %d after wait", queued, running)
+	}
+}
+
+// TestRESTWritesDispatched checks that a REST write waits behind the
+// WebSocket operations dispatched before it on the same trading system.
+func TestRESTWritesDispatched(t *testing.T) {
+	dbs := memory.NewDBServices()
+	th := NewTradeHandler(dbs)
+	var ids []uint
+	for _, symbol := range []string{"BTC", "ETH"} {
+		id, err := dbs.CreateTradingSystem(&model.TradingSystem{Symbol: symbol})
+		if err != nil {
+			t.Fatalf("CreateTradingSystem: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	patch := func(id uint) <-chan int {
+		status := make(chan int, 1)
+		go func() {
+			req := httptest.NewRequest(http.MethodPatch, "/trading-systems/"+strconv.FormatUint(uint64(id), 10), strings.NewReader(`{"quote_balance": 10}`))
+			rec := httptest.NewRecorder()
+			th.ServeHTTP(rec, req)
+			status <- rec.Code
+		}()
+		return status
+	}
+
+	release := make(chan struct{})
+	th.dispatcher().dispatch(ids[0], func() { <-release })
+	held := patch(ids[0])
+	// Another trading system is not held up
+	if status := <-patch(ids[1]); status != http.StatusOK {
+		t.Fatalf("PATCH of another trading system returned %d", status)
+	}
+	select {
+	case status := <-held:
+		t.Fatalf("PATCH returned %d before the operation dispatched ahead of it", status)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	if status := <-held; status != http.StatusOK {
+		t.Fatalf("PATCH returned %d", status)
+	}
+
+	th.dispatcher().close()
+	if status := <-patch(ids[0]); status != http.StatusServiceUnavailable {
+		t.Fatalf("PATCH during shutdown returned %d, want %d", status, http.StatusServiceUnavailable)
+	}
+}
+
+func TestMessageKey(t *testing.T) {
+	tests := []struct {
+		data map[string]interface{}
+		want uint
+	}{
+		{map[string]interface{}{"ID": float64(4), "symbol": "BTCUSDT"}, 4},
+		{map[string]interface{}{"id": float64(9), "revision": float64(2)}, 9},
+		{map[string]interface{}{"symbol": "BTCUSDT"}, 0},
+		{nil, 0},
+	}
+	for _, tt := range tests {
+		if got := messageKey(WebSocketMessage{Action: "update", Data: tt.data}); got != tt.want {
+			t.Errorf("messageKey(%v) = %d, want %d", tt.data, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/chidi150c/database/model"
+)
+
+// Change events pushed to subscribed connections.
+const (
+	EventCreated = "created"
+	EventUpdated = "updated"
+	EventDeleted = "deleted"
+)
+
+// WebSocketEvent is pushed to every connection subscribed to a trading system
+// whenever any connection changes it. Data holds the new state, or the last
+// state before removal for EventDeleted.
+type WebSocketEvent struct {
+	Event  string                   `json:"event"`
+	Entity string                   `json:"entity"`
+	DataID uint                     `json:"data_id"`
+	Data   *model.TradingSystemData `json:"data"`
+}
+
+// SubscribeRequest is the payload of the subscribe and unsubscribe actions.
+// Exactly one of ID, Symbol or All selects what to (un)subscribe; an empty
+// unsubscribe request drops every subscription of the connection.
+type SubscribeRequest struct {
+	ID     uint   `json:"id"`
+	Symbol string `json:"symbol"`
+	All    bool   `json:"all"`
+}
+
+// wsConn is a registered WebSocket connection. Replies and pushed events are
+// written from different goroutines, so every write goes through WriteJSON,
+// which queues it for the writer goroutine of the connection.
+type wsConn struct {
+	out *outbound
+	// client identifies the connection in the trading system history.
+	client string
+	// principal is the client the connection authenticated as, nil when
+	// authentication is disabled. revoked is set when its API key is
+	// revoked.
+	principal *principal
+	revoked   atomic.Bool
+
+	subMu   sync.Mutex
+	all     bool
+	ids     map[uint]struct{}
+	symbols map[string]struct{}
+}
+
+func newWSConn(conn frameWriter, client string, cfg WriterConfig, stats *writerStats) *wsConn {
+	return &wsConn{
+		out:     newOutbound(conn, cfg, stats),
+		client:  client,
+		ids:     make(map[uint]struct{}),
+		symbols: make(map[string]struct{}),
+	}
+}
+
+// WriteJSON serializes v as a single WebSocket message.
+func (c *wsConn) WriteJSON(v interface{}) error {
+	return c.out.writeJSON(v)
+}
+
+func (c *wsConn) subscribe(req SubscribeRequest) {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	switch {
+	case req.All:
+		c.all = true
+	case req.ID != 0:
+		c.ids[req.ID] = struct{}{}
+	case req.Symbol != "":
+		c.symbols[req.Symbol] = struct{}{}
+	}
+}
+
+func (c *wsConn) unsubscribe(req SubscribeRequest) {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	switch {
+	case req.All:
+		c.all = false
+	case req.ID != 0:
+		delete(c.ids, req.ID)
+	case req.Symbol != "":
+		delete(c.symbols, req.Symbol)
+	default:
+		c.all = false
+		c.ids = make(map[uint]struct{})
+		c.symbols = make(map[string]struct{})
+	}
+}
+
+// subscribed reports whether the connection wants events about ts.
+func (c *wsConn) subscribed(ts *model.TradingSystemData) bool {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	if c.all {
+		return true
+	}
+	if _, ok := c.ids[ts.ID]; ok {
+		return true
+	}
+	_, ok := c.symbols[ts.Symbol]
+	return ok
+}
+
+// publish pushes a change event about ts to every subscribed connection.
+func (th *TradeHandler) publish(event string, ts *model.TradingSystemData) {
+	ev := &WebSocketEvent{
+		Event:  event,
+		Entity: "trading-system",
+		DataID: ts.ID,
+		Data:   ts,
+	}
+	// Send outside the lock: under QueueBlock a slow subscriber may hold
+	// up the send, and must not hold up the other connections with it.
+	var subscribers []*wsConn
+	th.connections.RLock()
+	for c := range th.connections.m {
+		if c.subscribed(ts) {
+			subscribers = append(subscribers, c)
+		}
+	}
+	th.connections.RUnlock()
+	for _, c := range subscribers {
+		if err := c.WriteJSON(ev); err != nil {
+			log.Println("Error pushing event via WebSocket:", err)
+		}
+	}
+}
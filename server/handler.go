@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/chidi150c/database/gorm"
 	"github.com/chidi150c/database/model"
 	"github.com/go-chi/chi"
 	"github.com/gorilla/websocket"
@@ -15,61 +17,151 @@ import (
 
 // Define a struct that matches the expected WebSocket message format
 type WebSocketMessage struct {
-	Action string                 `json:"action"`
-	Entity string                 `json:"entity"`
-	Data   map[string]interface{} `json:"data"`
+	// RequestID is an opaque, client-supplied correlation ID. It is echoed
+	// unchanged in the response envelope so that a client pipelining several
+	// requests on one connection can match each reply to its request.
+	RequestID string                 `json:"request_id,omitempty"`
+	Action    string                 `json:"action"`
+	Entity    string                 `json:"entity"`
+	Data      map[string]interface{} `json:"data"`
 }
 
-// WebService is a user login-aware wrapper for a html/template.
-var connections = struct {
-	sync.RWMutex
-	m map[*websocket.Conn]struct{}
-}{
-	m: make(map[*websocket.Conn]struct{}),
+// actionEntities maps every WebSocket action to the entity it applies to.
+var actionEntities = map[string]string{
+	"create":       "trading-system",
+	"read":         "trading-system",
+	"list":         "trading-system",
+	"update":       "trading-system",
+	"patch":        "trading-system",
+	"append-price": "trading-system",
+	"delete":       "trading-system",
+	"subscribe":    "trading-system",
+	"unsubscribe":  "trading-system",
+	"history":      "trading-system",
+	"revert":       "trading-system",
+	"read-prices":  "price",
+	"create-key":   "api-key",
+	"list-keys":    "api-key",
+	"revoke-key":   "api-key",
 }
 
+// ReadRequest is the payload of the read action. A zero ID reads the latest
+// trading system. Revision or AsOf, if set, select an earlier version.
+type ReadRequest struct {
+	ID       uint       `json:"id"`
+	Revision uint       `json:"revision,omitempty"`
+	AsOf     *time.Time `json:"as_of,omitempty"`
+}
+
+// RevertRequest is the payload of the revert action.
+type RevertRequest struct {
+	ID       uint `json:"id"`
+	Revision uint `json:"revision"`
+}
+
+// AppendPriceRequest is the payload of the append-price action.
+type AppendPriceRequest struct {
+	ID     uint               `json:"id"`
+	Points []model.PricePoint `json:"points"`
+}
+
+// TradeHandler serves the database services over HTTP. Every connection shares
+// the single DBServicer it is built around.
 type TradeHandler struct {
-	mux    *chi.Mux
-	DbName string
+	mux *chi.Mux
+	dbs model.DBServicer
+
+	// Keys holds the API keys clients authenticate with. CertScopes grants
+	// scopes to the clients that present a verified TLS certificate, keyed
+	// by CertIdentity, with "*" for any other certificate. Authentication
+	// is disabled while both are nil.
+	Keys       model.APIKeyServicer
+	CertScopes map[string][]string
+	// Origins lists the browser origins allowed to use the handler. Every
+	// origin is allowed when it is nil.
+	Origins *OriginPolicy
+
+	// Writer configures the outbound queue of the WebSocket connections
+	// opened after it is set.
+	Writer      WriterConfig
+	writerStats writerStats
+
+	// Concurrency limits the WebSocket operations processed at once,
+	// DefaultConcurrency when zero, and as many more may wait for their
+	// turn before the connections stop being read. It must be set before
+	// serving.
+	Concurrency  int
+	dispatchOnce sync.Once
+	dispatch     *dispatcher
+
+	// draining is set by Shutdown: new connections are refused.
+	draining atomic.Bool
+
+	// connections records every open socket so changes can be pushed to
+	// the ones that subscribed to them.
+	connections struct {
+		sync.RWMutex
+		m map[*wsConn]struct{}
+		// seq numbers the connections for their client identity.
+		seq uint64
+	}
 }
 
-func NewTradeHandler(dbName string) TradeHandler {
-	h := TradeHandler{
-		mux:    chi.NewRouter(),
-		DbName: dbName,
+func NewTradeHandler(dbs model.DBServicer) *TradeHandler {
+	h := &TradeHandler{
+		mux: chi.NewRouter(),
+		dbs: dbs,
 	}
+	h.connections.m = make(map[*wsConn]struct{})
+	h.mux.Use(h.requireOrigin)
 	h.mux.Get("/database-services/ws", h.DataBaseSocketHandler)
+	h.mux.With(h.requireKey).Get("/database-services/stats", h.handleStats)
+	h.mux.Route("/trading-systems", h.tradingSystemRoutes)
 	return h
 }
 
-func (h TradeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+func (h *TradeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.mux.ServeHTTP(w, r)
 }
 func (th *TradeHandler) DataBaseSocketHandler(w http.ResponseWriter, r *http.Request) {
 	upgrader := websocket.Upgrader{
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
+		// The origin was already checked by requireOrigin, which answers
+		// a clear 403 instead of failing the handshake
 		CheckOrigin: func(r *http.Request) bool {
-			// 		if r.Header.Get("Origin") != h.HostSite {
-			// 			return false
-			// 		}
 			return true
 		},
 	}
-	conn, err := upgrader.Upgrade(w, r, nil)
+	// Refuse unauthenticated clients before upgrading, with a plain HTTP
+	// error they can read
+	if th.draining.Load() {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	p, err := th.authenticate(r)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println(err)
 		return
 	}
-	defer conn.Close()
+	defer ws.Close()
 
 	//Register the conn in connections
-	connections.Lock()
-	connections.m[conn] = struct{}{}
-	connections.Unlock()
+	th.connections.Lock()
+	th.connections.seq++
+	conn := newWSConn(ws, clientName(fmt.Sprintf("ws:%d@%s", th.connections.seq, r.RemoteAddr), p), th.Writer, &th.writerStats)
+	conn.principal = p
+	th.connections.m[conn] = struct{}{}
+	th.connections.Unlock()
+	defer conn.out.close()
 
 	for {
-		_, p, err := conn.ReadMessage()
+		_, p, err := ws.ReadMessage()
 		if err != nil {
 			log.Print("ConnRDErr")
 			break
@@ -78,280 +170,285 @@ func (th *TradeHandler) DataBaseSocketHandler(w http.ResponseWriter, r *http.Req
 		var msg WebSocketMessage
 		if err := json.Unmarshal(p, &msg); err != nil {
 			log.Println(err)
+			// Echo the request ID when only the data is malformed, so the
+			// client can still match the error to its request.
+			var envelope struct {
+				RequestID string `json:"request_id"`
+			}
+			json.Unmarshal(p, &envelope)
+			writeError(model.Errorf(model.CodeInvalidPayload, "Error parsing WebSocket message: %v", err), envelope.RequestID, conn)
 			continue
 		}
 
-		go processMessage(conn, msg, th.DbName)
+		if !th.dispatcher().dispatch(messageKey(msg), func() { th.processMessage(conn, msg) }) {
+			writeError(model.Errorf(model.CodeUnavailable, "Server is shutting down"), msg.RequestID, conn)
+		}
 	}
-	connections.Lock()
-	delete(connections.m, conn)
-	connections.Unlock()
+	th.connections.Lock()
+	delete(th.connections.m, conn)
+	th.connections.Unlock()
 }
-func processMessage(conn *websocket.Conn, message WebSocketMessage, dbName string) {
-	msg := ""
-	DBServices, err := gorm.NewDBServices(dbName)
-	if err != nil {
-		log.Printf("Error: while processing Websocket massge: %v", err)
+func (th *TradeHandler) processMessage(conn *wsConn, message WebSocketMessage) {
+	entity, ok := actionEntities[message.Action]
+	if !ok {
+		log.Printf("Unknown action: %s", message.Action)
+		writeError(model.Errorf(model.CodeUnknownAction, "Unknown action %q", message.Action), message.RequestID, conn)
+		return
+	}
+	if message.Entity != entity {
+		writeError(model.Errorf(model.CodeUnknownEntity, "Unknown entity %q for action %q", message.Entity, message.Action), message.RequestID, conn)
+		return
+	}
+	if conn.revoked.Load() {
+		writeError(model.Errorf(model.CodeUnauthorized, "API key %s was revoked", conn.principal.key.Prefix), message.RequestID, conn)
+		return
+	}
+	if err := th.authorize(conn.principal, actionScopes[message.Action]); err != nil {
+		writeError(err, message.RequestID, conn)
+		return
+	}
+	if entity == "api-key" {
+		th.processKeyMessage(conn, message)
 		return
 	}
 	switch message.Action {
 	case "create":
-		// Handle create action for entities
-		if message.Entity == "trading-system" {
-			// Parse and process trading system creation
-			var ts model.TradingSystemData
-			dataByte, _ := json.Marshal(message.Data)
-			// Deserialize the WebSocket message directly into the struct
-			if err := json.Unmarshal(dataByte, &ts); err != nil {
-				msg = fmt.Sprintf("Error3 parsing WebSocket message: %v", err)
-				writeResponseWithID(msg, ts.ID, conn)
-				return
-			}
-			// Convert standard types to custom data types
-			dbTrade := &model.TradingSystem{
-				Symbol:                   ts.Symbol,
-				ClosingPrices:            model.Float64Slice(ts.ClosingPrices),
-				Timestamps:               model.Int64Slice(ts.Timestamps),
-				Signals:                  model.StringSlice(ts.Signals),
-				NextInvestBuYPrice:       model.Float64Slice(ts.NextInvestBuYPrice),
-				NextProfitSeLLPrice:      model.Float64Slice(ts.NextProfitSeLLPrice),
-				CommissionPercentage:     ts.CommissionPercentage,
-				InitialCapital:           ts.InitialCapital,
-				PositionSize:             ts.PositionSize,
-				EntryPrice:               model.Float64Slice(ts.EntryPrice),
-				InTrade:                  ts.InTrade,
-				QuoteBalance:             ts.QuoteBalance,
-				BaseBalance:              ts.BaseBalance,
-				RiskCost:                 ts.RiskCost,
-				DataPoint:                ts.DataPoint,
-				CurrentPrice:             ts.CurrentPrice,
-				EntryQuantity:            model.Float64Slice(ts.EntryQuantity),
-				EntryCostLoss:            model.Float64Slice(ts.EntryCostLoss),
-				TradeCount:               ts.TradeCount,
-				TradingLevel:             ts.TradingLevel,
-				ClosedWinTrades:          ts.ClosedWinTrades,
-				EnableStoploss:           ts.EnableStoploss,
-				StopLossTrigered:         ts.StopLossTrigered,
-				StopLossRecover:          model.Float64Slice(ts.StopLossRecover),
-				RiskFactor:               ts.RiskFactor,
-				MaxDataSize:              ts.MaxDataSize,
-				RiskProfitLossPercentage: ts.RiskProfitLossPercentage,
-				BaseCurrency:             ts.BaseCurrency,
-				QuoteCurrency:            ts.QuoteCurrency,
-				MiniQty:                  ts.MiniQty,
-				MaxQty:                   ts.MaxQty,
-				MinNotional:              ts.MinNotional,
-				StepSize:                 ts.StepSize,
-				TargetStopLoss:           ts.TargetStopLoss,
-				TargetProfit:             ts.TargetProfit,
-				TotalProfitLoss:          ts.TotalProfitLoss,
-				RiskPositionPercentage:   ts.RiskPositionPercentage,
-				ShortPeriod:              ts.ShortPeriod,
-				LongPeriod:               ts.LongPeriod,
-			}
-			// Insert the new trading system into the database
-			tradeID, err := DBServices.CreateTradingSystem(dbTrade)
-			if err != nil {
-				msg = fmt.Sprintf("Error creating trading system: %v", err)
-				writeResponseWithID(msg, tradeID, conn)
-				return
-			}
-			writeResponseWithID("TradingSystem Created successfully", tradeID, conn)
+		// Parse and process trading system creation
+		var ts model.TradingSystemData
+		if err := decodeData(message.Data, &ts); err != nil {
+			writeError(err, message.RequestID, conn)
+			return
 		}
+		dbTrade, err := th.createTradingSystem(conn.client, &ts)
+		if err != nil {
+			writeError(err, message.RequestID, conn)
+			return
+		}
+		writeRevisionResponse("TradingSystem Created successfully", dbTrade, message.RequestID, conn)
 	case "read":
-		if message.Entity == "trading-system" {
-			var ts model.TradingSystem
-			dataByte, _ := json.Marshal(message.Data)
-			// Deserialize the WebSocket message directly into the struct
-			if err := json.Unmarshal(dataByte, &ts); err != nil {
-				msg = fmt.Sprintf("Error5 parsing WebSocket message: %v", err)
-				writeResponseWithData(msg, ts, conn)
-				return
-			}
-			tradeID := ts.ID
-			// Fetch the trading system from the database based on tradeID
-			dbTrade, err := DBServices.ReadTradingSystem(tradeID)
-			if err != nil {
-				msg = fmt.Sprintf("Error retrieving trading system: %v", err)
-				writeResponseWithData(msg, &model.TradingSystemData{}, conn)
-				return
-			}
-			// Convert custom data types to standard types
-			dataTrade := &model.TradingSystemData{
-				ID:                       dbTrade.ID,
-				Symbol:                   dbTrade.Symbol,
-				ClosingPrices:            []float64(dbTrade.ClosingPrices),
-				Timestamps:               []int64(dbTrade.Timestamps),
-				Signals:                  []string(dbTrade.Signals),
-				NextInvestBuYPrice:       []float64(dbTrade.NextInvestBuYPrice),
-				NextProfitSeLLPrice:      []float64(dbTrade.NextProfitSeLLPrice),
-				CommissionPercentage:     dbTrade.CommissionPercentage,
-				InitialCapital:           dbTrade.InitialCapital,
-				PositionSize:             dbTrade.PositionSize,
-				EntryPrice:               []float64(dbTrade.EntryPrice),
-				InTrade:                  dbTrade.InTrade,
-				QuoteBalance:             dbTrade.QuoteBalance,
-				BaseBalance:              dbTrade.BaseBalance,
-				RiskCost:                 dbTrade.RiskCost,
-				DataPoint:                dbTrade.DataPoint,
-				CurrentPrice:             dbTrade.CurrentPrice,
-				EntryQuantity:            []float64(dbTrade.EntryQuantity),
-				EntryCostLoss:            []float64(dbTrade.EntryCostLoss),
-				TradeCount:               dbTrade.TradeCount,
-				TradingLevel:             dbTrade.TradingLevel,
-				ClosedWinTrades:          dbTrade.ClosedWinTrades,
-				EnableStoploss:           dbTrade.EnableStoploss,
-				StopLossTrigered:         dbTrade.StopLossTrigered,
-				StopLossRecover:          []float64(dbTrade.StopLossRecover),
-				RiskFactor:               dbTrade.RiskFactor,
-				MaxDataSize:              dbTrade.MaxDataSize,
-				RiskProfitLossPercentage: dbTrade.RiskProfitLossPercentage,
-				BaseCurrency:             dbTrade.BaseCurrency,
-				QuoteCurrency:            dbTrade.QuoteCurrency,
-				MiniQty:                  dbTrade.MiniQty,
-				MaxQty:                   dbTrade.MaxQty,
-				MinNotional:              dbTrade.MinNotional,
-				StepSize:                 dbTrade.StepSize,
-				TargetStopLoss:           dbTrade.TargetStopLoss,
-				TargetProfit:             dbTrade.TargetProfit,
-				TotalProfitLoss:          dbTrade.TotalProfitLoss,
-				RiskPositionPercentage:   dbTrade.RiskPositionPercentage,
-				ShortPeriod:              dbTrade.ShortPeriod,
-				LongPeriod:               dbTrade.LongPeriod,
-			}
-			writeResponseWithData("TradingSystem Read successfully", dataTrade, conn)
+		var req ReadRequest
+		if err := decodeData(message.Data, &req); err != nil {
+			writeError(err, message.RequestID, conn)
+			return
+		}
+		// Fetch the trading system from the database based on tradeID
+		dbTrade, err := th.readTradingSystem(req)
+		if err != nil {
+			writeError(err, message.RequestID, conn)
+			return
+		}
+		// Convert custom data types to standard types
+		writeResponseWithData("TradingSystem Read successfully", dbTrade.Data(), message.RequestID, conn)
+	case "list":
+		var filter model.TradingSystemFilter
+		if err := decodeData(message.Data, &filter); err != nil {
+			writeError(err, message.RequestID, conn)
+			return
 		}
+		data, next, err := th.listTradingSystems(filter)
+		if err != nil {
+			writeError(err, message.RequestID, conn)
+			return
+		}
+		writeResponse(&model.Response{
+			RequestID:  message.RequestID,
+			Status:     model.StatusOK,
+			Message:    "TradingSystems Listed successfully",
+			Data:       data,
+			NextCursor: next,
+		}, conn)
 	case "update":
-		if message.Entity == "trading-system" {
-			var ts model.TradingSystemData
-			dataByte, _ := json.Marshal(message.Data)
-			// Deserialize the WebSocket message directly into the struct
-			if err := json.Unmarshal(dataByte, &ts); err != nil {
-				msg = fmt.Sprintf("Error6 parsing WebSocket message: %v", err)
-				writeResponseWithID(msg, ts.ID, conn)
-				return
-			}
-			// Fetch the existing trading system from the database based on tradeID
-			existingTrade, err := DBServices.ReadTradingSystem(ts.ID)
-			if err != nil {
-				msg = fmt.Sprintf("Error retrieving trading system for update: %v", err)
-				fmt.Printf("Error retrieving trading system %d for update: %v", ts.ID, err)
-				writeResponseWithID(msg, ts.ID, conn)
-				return
-			} else if ts.ID != existingTrade.ID {
-				msg = fmt.Sprintf("Error retrieving trading system for update: ts.ID %d != existingTrade.ID %d %v", ts.ID, existingTrade.ID, err)
-				fmt.Printf("Error retrieving trading system %d for update: ts.ID %d != existingTrade.ID %d %v", ts.ID, ts.ID, existingTrade.ID, err)
-				writeResponseWithID(msg, ts.ID, conn)
-				return
-			}
-			// Update the existing trading system fields with new data
-			existingTrade.Symbol = ts.Symbol
-			existingTrade.ClosingPrices = model.Float64Slice(ts.ClosingPrices)
-			existingTrade.Timestamps = model.Int64Slice(ts.Timestamps)
-			existingTrade.Signals = model.StringSlice(ts.Signals)
-			existingTrade.NextInvestBuYPrice = model.Float64Slice(ts.NextInvestBuYPrice)
-			existingTrade.NextProfitSeLLPrice = model.Float64Slice(ts.NextProfitSeLLPrice)
-			existingTrade.CommissionPercentage = ts.CommissionPercentage
-			existingTrade.InitialCapital = ts.InitialCapital
-			existingTrade.PositionSize = ts.PositionSize
-			existingTrade.EntryPrice = model.Float64Slice(ts.EntryPrice)
-			existingTrade.InTrade = ts.InTrade
-			existingTrade.QuoteBalance = ts.QuoteBalance
-			existingTrade.BaseBalance = ts.BaseBalance
-			existingTrade.RiskCost = ts.RiskCost
-			existingTrade.DataPoint = ts.DataPoint
-			existingTrade.CurrentPrice = ts.CurrentPrice
-			existingTrade.EntryQuantity = model.Float64Slice(ts.EntryQuantity)
-			existingTrade.EntryCostLoss = model.Float64Slice(ts.EntryCostLoss)
-			existingTrade.TradeCount = ts.TradeCount
-			existingTrade.TradingLevel = ts.TradingLevel
-			existingTrade.ClosedWinTrades = ts.ClosedWinTrades
-			existingTrade.EnableStoploss = ts.EnableStoploss
-			existingTrade.StopLossTrigered = ts.StopLossTrigered
-			existingTrade.StopLossRecover = model.Float64Slice(ts.StopLossRecover)
-			existingTrade.RiskFactor = ts.RiskFactor
-			existingTrade.MaxDataSize = ts.MaxDataSize
-			existingTrade.RiskProfitLossPercentage = ts.RiskProfitLossPercentage
-			existingTrade.BaseCurrency = ts.BaseCurrency
-			existingTrade.QuoteCurrency = ts.QuoteCurrency
-			existingTrade.MiniQty = ts.MiniQty
-			existingTrade.MaxQty = ts.MaxQty
-			existingTrade.MinNotional = ts.MinNotional
-			existingTrade.StepSize = ts.StepSize
-			existingTrade.TargetStopLoss = ts.TargetStopLoss
-			existingTrade.TargetProfit = ts.TargetProfit
-			existingTrade.TotalProfitLoss = ts.TotalProfitLoss
-			existingTrade.RiskPositionPercentage = ts.RiskPositionPercentage
-			existingTrade.ShortPeriod = ts.ShortPeriod
-			existingTrade.LongPeriod = ts.LongPeriod
-
-			// Save the updated trading system back to the database
-			err = DBServices.UpdateTradingSystem(existingTrade)
-			if err != nil {
-				msg = fmt.Sprintf("Error updating trading system: %v", err)
-				writeResponseWithID(msg, existingTrade.ID, conn)
-				return
-			}
-			writeResponseWithID("Trading system updated successfully", existingTrade.ID, conn)
+		var ts model.TradingSystemData
+		if err := decodeData(message.Data, &ts); err != nil {
+			writeError(err, message.RequestID, conn)
+			return
+		}
+		dbTrade, err := th.updateTradingSystem(conn.client, &ts)
+		if err != nil {
+			writeError(err, message.RequestID, conn)
+			return
+		}
+		writeRevisionResponse("Trading system updated successfully", dbTrade, message.RequestID, conn)
+	case "patch":
+		// The data is a JSON merge patch that also carries the target ID
+		tradeID, patch, err := splitPatch(message.Data)
+		if err != nil {
+			writeError(err, message.RequestID, conn)
+			return
+		}
+		dbTrade, err := th.patchTradingSystem(conn.client, tradeID, patch)
+		if err != nil {
+			writeError(err, message.RequestID, conn)
+			return
+		}
+		writeRevisionResponse("Trading system patched successfully", dbTrade, message.RequestID, conn)
+	case "append-price":
+		var req AppendPriceRequest
+		if err := decodeData(message.Data, &req); err != nil {
+			writeError(err, message.RequestID, conn)
+			return
+		}
+		dbTrade, err := th.appendPrices(conn.client, req.ID, req.Points)
+		if err != nil {
+			writeError(err, message.RequestID, conn)
+			return
+		}
+		writeRevisionResponse("Prices appended successfully", dbTrade, message.RequestID, conn)
+	case "revert":
+		var req RevertRequest
+		if err := decodeData(message.Data, &req); err != nil {
+			writeError(err, message.RequestID, conn)
+			return
+		}
+		dbTrade, err := th.revertTradingSystem(conn.client, req.ID, req.Revision)
+		if err != nil {
+			writeError(err, message.RequestID, conn)
+			return
 		}
+		writeRevisionResponse("Trading system reverted successfully", dbTrade, message.RequestID, conn)
+	case "history":
+		var q model.HistoryQuery
+		if err := decodeData(message.Data, &q); err != nil {
+			writeError(err, message.RequestID, conn)
+			return
+		}
+		entries, next, err := th.dbs.ListHistory(q)
+		if err != nil {
+			writeError(err, message.RequestID, conn)
+			return
+		}
+		writeResponse(&model.Response{
+			RequestID:  message.RequestID,
+			Status:     model.StatusOK,
+			Message:    "History Read successfully",
+			Data:       entries,
+			NextCursor: next,
+		}, conn)
+	case "read-prices":
+		var q model.PriceQuery
+		if err := decodeData(message.Data, &q); err != nil {
+			writeError(err, message.RequestID, conn)
+			return
+		}
+		ticks, err := th.dbs.ReadPrices(q)
+		if err != nil {
+			writeError(err, message.RequestID, conn)
+			return
+		}
+		writeResponseWithData("Prices Read successfully", ticks, message.RequestID, conn)
 	case "delete":
-		if message.Entity == "trading-system" {
-			var ts model.TradingSystemData
-			dataByte, _ := json.Marshal(message.Data)
-			// Deserialize the WebSocket message directly into the struct
-			if err := json.Unmarshal(dataByte, &ts); err != nil {
-				msg = fmt.Sprintf("Error8 parsing WebSocket message: %v", err)
-				return
-			}
-			// Delete the trading system from the database based on tradeID
-			err := DBServices.DeleteTradingSystem(ts.ID)
-			if err != nil {
-				msg = fmt.Sprintf("Error deleting trading system: %v", err)
-				return
-			}
-			// Send a success response back to the client via the conn
-			response := map[string]interface{}{
-				"message": "Trading system deleted successfully",
-				"data_id": ts.ID,
-			}
-			err = conn.WriteJSON(response)
-			if err != nil {
-				msg = fmt.Sprintf("Error sending response via WebSocket: %v", err)
+		var ts model.TradingSystemData
+		if err := decodeData(message.Data, &ts); err != nil {
+			writeError(err, message.RequestID, conn)
+			return
+		}
+		if err := th.deleteTradingSystem(conn.client, ts.ID); err != nil {
+			writeError(err, message.RequestID, conn)
+			return
+		}
+		// Send a success response back to the client via the conn
+		writeResponseWithID("Trading system deleted successfully", ts.ID, message.RequestID, conn)
+	case "subscribe", "unsubscribe":
+		var req SubscribeRequest
+		if err := decodeData(message.Data, &req); err != nil {
+			writeError(err, message.RequestID, conn)
+			return
+		}
+		if message.Action == "subscribe" {
+			if !req.All && req.ID == 0 && req.Symbol == "" {
+				writeError(model.Errorf(model.CodeInvalidPayload, "Subscription needs an id, a symbol or all"), message.RequestID, conn)
 				return
 			}
+			conn.subscribe(req)
+			writeResponseWithID("Subscribed successfully", req.ID, message.RequestID, conn)
+			return
 		}
-	default:
-		log.Printf("Unknown action: %s", message.Action)
-		// msg = fmt.Sprintf("Invalid action in WebSocket message")
+		conn.unsubscribe(req)
+		writeResponseWithID("Unsubscribed successfully", req.ID, message.RequestID, conn)
 	}
 }
-func writeResponseWithID(msg string, id uint, conn *websocket.Conn) {
-	// Send the dataID back to the client via the conn
-	response := map[string]interface{}{
-		"message": msg,
-		"data_id": id,
-	}
-	err := conn.WriteJSON(response)
+
+// decodeData deserializes the data of a WebSocket message into v.
+func decodeData(data map[string]interface{}, v interface{}) error {
+	dataByte, err := json.Marshal(data)
 	if err != nil {
-		log.Println("Error sending response via WebSocket:", err)
-		return
+		return model.Errorf(model.CodeInvalidPayload, "Error parsing WebSocket message data: %v", err)
 	}
+	if err := json.Unmarshal(dataByte, v); err != nil {
+		return model.Errorf(model.CodeInvalidPayload, "Error parsing WebSocket message data: %v", err)
+	}
+	return nil
 }
-func writeResponseWithData(msg string, data interface{}, conn *websocket.Conn) {
-	// Serialize the data object to JSON
-	dataJSON, err := json.Marshal(data)
+
+// splitPatch separates the target ID from the merge patch in the data of a
+// patch message.
+func splitPatch(data map[string]interface{}) (uint, []byte, error) {
+	var target struct{ ID uint }
+	if err := decodeData(data, &target); err != nil {
+		return 0, nil, err
+	}
+	patch := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		if !strings.EqualFold(k, "id") {
+			patch[k] = v
+		}
+	}
+	b, err := json.Marshal(patch)
 	if err != nil {
-		log.Println("Error marshaling Data to JSON:", err)
-		return
+		return 0, nil, model.Errorf(model.CodeInvalidPayload, "Error parsing patch: %v", err)
 	}
+	return target.ID, b, nil
+}
+
+func writeResponseWithID(msg string, id uint, requestID string, conn *wsConn) {
+	// Send the dataID back to the client via the conn
+	writeResponse(&model.Response{
+		RequestID: requestID,
+		Status:    model.StatusOK,
+		Message:   msg,
+		DataID:    id,
+	}, conn)
+}
+
+// writeRevisionResponse acknowledges a write with the ID and new revision of
+// the trading system.
+func writeRevisionResponse(msg string, trade *model.TradingSystem, requestID string, conn *wsConn) {
+	writeResponse(&model.Response{
+		RequestID: requestID,
+		Status:    model.StatusOK,
+		Message:   msg,
+		DataID:    trade.ID,
+		Revision:  trade.Revision,
+	}, conn)
+}
+
+func writeResponseWithData(msg string, data interface{}, requestID string, conn *wsConn) {
 	// Send the data back to the client via the conn
-	response := map[string]interface{}{
-		"message": msg,
-		"data":    json.RawMessage(dataJSON),
+	writeResponse(&model.Response{
+		RequestID: requestID,
+		Status:    model.StatusOK,
+		Message:   msg,
+		Data:      data,
+	}, conn)
+}
+
+// writeError reports err to the client. The code is taken from the typed
+// errors of the model package; anything else is reported as internal.
+func writeError(err error, requestID string, conn *wsConn) {
+	re := model.NewResponseError(err)
+	if re.Code == model.CodeInternal {
+		log.Printf("Error: while processing Websocket message: %v", err)
 	}
-	err = conn.WriteJSON(response)
-	if err != nil {
+	writeResponse(&model.Response{
+		RequestID: requestID,
+		Status:    model.StatusError,
+		Message:   re.Message,
+		Error:     re,
+	}, conn)
+}
+
+func writeResponse(response *model.Response, conn *wsConn) {
+	if err := conn.WriteJSON(response); err != nil {
 		log.Println("Error sending response via WebSocket:", err)
-		return
 	}
 }
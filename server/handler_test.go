@@ -1,97 +1,197 @@
-package server
+package server_test
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"testing"
 
+	"github.com/chidi150c/database/client"
 	"github.com/chidi150c/database/model"
-	"github.com/gorilla/websocket"
+	"github.com/chidi150c/database/server"
+	"github.com/chidi150c/database/servertest"
 )
 
-func TestCreateAppData(t *testing.T) {
-    // Create a mock WebSocket connection
-    conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:35261/database-services/ws", nil)
-    if err != nil {
-        t.Fatalf("Failed to connect to WebSocket: %v", err)
-    }
-    defer conn.Close()
-
-    // Create a AppData object
-    ap := model.AppData{
-        DataPoint: 234,
-		Strategy: "EMA",
-		ShortPeriod: 65,
-    }
-	ap.ID = uint(2)
-
-	// Serialize the AppData object to JSON
-	appDataJSON, err := json.Marshal(ap)
-	if err != nil {
-		t.Fatalf("Error marshaling AppData to JSON: %v", err)
-	}
-
-	// Create a message (request) to send
-	request := map[string]interface{}{
-		"action": "create",
-		"entity": "app-data",
-		"data":   json.RawMessage(appDataJSON), // RawMessage to keep it as JSON
-	}
-	
-    // Send the message
-    err = conn.WriteJSON(request)
-    if err != nil {
-        t.Fatalf("Failed to send WebSocket message: %v", err)
-    }
-
-    // Receive and parse the response
-    var response map[string]interface{}
-    err = conn.ReadJSON(&response)
-    if err != nil {
-        t.Fatalf("Failed to read WebSocket response: %v", err)
-    }
-
-    // Perform assertions to verify the response
-    ms, ok := response["message"].(string)
-    if !ok {
-        t.Fatalf("Invalid response format %v and resp: %v", ms, response)
-    }
-	fmt.Printf("tradeID in uint correct: %v\n", ms)
-
-    // Add more assertions as needed
-	tradeID := uint(2)
-	// Create a message (request) to send
-	request = map[string]interface{}{
-		"action": "read",
-		"entity": "app-data",
-		"data": map[string]interface{}{
-			"data_id":       tradeID,
-		},
-	}
-
-	// Send the message
-	err = conn.WriteJSON(request)
+func newTradingSystem(symbol string) *model.TradingSystemData {
+	return &model.TradingSystemData{
+		Symbol:         symbol,
+		BaseCurrency:   "BTC",
+		QuoteCurrency:  "USDT",
+		ClosingPrices:  []float64{100, 101.5, 99.25},
+		Timestamps:     []int64{1, 2, 3},
+		TargetStopLoss: 95,
+	}
+}
+
+func TestTradingSystemCRUD(t *testing.T) {
+	c := servertest.NewServer(t).Dial(t)
+	ctx, cancel := context.WithTimeout(context.Background(), servertest.Timeout)
+	defer cancel()
+
+	id, rev, err := c.CreateTradingSystem(ctx, newTradingSystem("BTCUSDT"))
 	if err != nil {
-		t.Fatalf("Failed to send WebSocket message: %v", err)
+		t.Fatalf("CreateTradingSystem: %v", err)
+	}
+	if id == 0 || rev != 1 {
+		t.Fatalf("CreateTradingSystem returned id %d revision %d, want a new id at revision 1", id, rev)
 	}
 
-	err = conn.ReadJSON(&response)
+	ts, err := c.ReadTradingSystem(ctx, id)
 	if err != nil {
-		t.Fatalf("Failed to read WebSocket response: %v", err)
+		t.Fatalf("ReadTradingSystem: %v", err)
+	}
+	if ts.ID != id || ts.Symbol != "BTCUSDT" || ts.Revision != 1 || len(ts.ClosingPrices) != 3 || ts.ClosingPrices[1] != 101.5 {
+		t.Fatalf("ReadTradingSystem returned %+v", ts)
+	}
+
+	ts.TargetStopLoss = 90
+	if rev, err = c.Update(ctx, ts); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if rev != 2 {
+		t.Fatalf("Update returned revision %d, want 2", rev)
 	}
-	// Marshal the map into a JSON byte slice
-	tradingSystemBytes, err := json.Marshal(response)
+	if rev, err = c.Patch(ctx, id, map[string]interface{}{"revision": rev, "symbol": "ETHUSDT"}); err != nil {
+		t.Fatalf("Patch: %v", err)
+	}
+	if ts, err = c.ReadTradingSystem(ctx, id); err != nil {
+		t.Fatalf("ReadTradingSystem: %v", err)
+	}
+	if ts.Revision != rev || ts.Symbol != "ETHUSDT" || ts.TargetStopLoss != 90 {
+		t.Fatalf("ReadTradingSystem after update and patch returned %+v", ts)
+	}
+
+	list, _, err := c.List(ctx, model.TradingSystemFilter{})
 	if err != nil {
-		t.Fatalf("Error marshaling AppData: %v", err)
+		t.Fatalf("List: %v", err)
+	}
+	if len(list) != 1 || list[0].ID != id {
+		t.Fatalf("List returned %d trading systems, want only %d", len(list), id)
 	}
 
-	// Unmarshal the JSON byte slice into a AppData struct
-	var responseAppData model.AppData
-	err = json.Unmarshal(tradingSystemBytes, &responseAppData)
+	if err := c.Delete(ctx, id); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := c.ReadTradingSystem(ctx, id); client.ErrorCode(err) != model.CodeNotFound {
+		t.Fatalf("ReadTradingSystem after delete returned %v, want %s", err, model.CodeNotFound)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	c := servertest.NewServer(t).Dial(t)
+	ctx, cancel := context.WithTimeout(context.Background(), servertest.Timeout)
+	defer cancel()
+	id, _, err := c.CreateTradingSystem(ctx, newTradingSystem("BTCUSDT"))
 	if err != nil {
-		t.Fatalf("Failed to unmarshal WebSocket response: %v", err)
+		t.Fatalf("CreateTradingSystem: %v", err)
 	}
 
-	fmt.Printf("AppData correct: %+v", responseAppData)
+	stale := newTradingSystem("BTCUSDT")
+	stale.ID = id
+	stale.Revision = 1
+	stale.TargetStopLoss = 80
+	if _, err := c.Update(ctx, stale); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
 
+	tests := []struct {
+		name   string
+		action string
+		entity string
+		data   interface{}
+		code   string
+	}{
+		{"unknown action", "explode", "trading-system", nil, model.CodeUnknownAction},
+		{"unknown entity", "read", "app-data", server.ReadRequest{ID: id}, model.CodeUnknownEntity},
+		{"invalid payload", "create", "trading-system", "not an object", model.CodeInvalidPayload},
+		{"read missing", "read", "trading-system", server.ReadRequest{ID: id + 100}, model.CodeNotFound},
+		{"delete missing", "delete", "trading-system", map[string]uint{"id": id + 100}, model.CodeNotFound},
+		{"stale update", "update", "trading-system", stale, model.CodeConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := c.Send(ctx, tt.action, tt.entity, tt.data, nil)
+			if client.ErrorCode(err) != tt.code {
+				t.Fatalf("got error %v, want %s", err, tt.code)
+			}
+			if resp.Status != model.StatusError {
+				t.Fatalf("got status %q, want %q", resp.Status, model.StatusError)
+			}
+		})
+	}
+
+	// A conflict tells the client which revision to re-read.
+	_, err = c.Update(ctx, stale)
+	var re *model.ResponseError
+	if !errors.As(err, &re) || re.CurrentRevision != 2 {
+		t.Fatalf("stale update returned %v, want current revision 2", err)
+	}
+}
+
+func TestConcurrentClients(t *testing.T) {
+	s := servertest.NewServer(t)
+	watcher := s.Dial(t)
+	ctx, cancel := context.WithTimeout(context.Background(), servertest.Timeout)
+	defer cancel()
+	if err := watcher.Subscribe(ctx, client.SubscribeRequest{All: true}); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	const clients, perClient = 8, 5
+	ids := make(chan uint, clients*perClient)
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		c := s.Dial(t)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < perClient; j++ {
+				id, _, err := c.CreateTradingSystem(ctx, newTradingSystem(fmt.Sprintf("SYM%d", i)))
+				if err != nil {
+					t.Errorf("client %d: CreateTradingSystem: %v", i, err)
+					return
+				}
+				ids <- id
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(ids)
+	if t.Failed() {
+		return
+	}
+
+	seen := make(map[uint]bool)
+	for id := range ids {
+		if seen[id] {
+			t.Fatalf("id %d was returned twice", id)
+		}
+		seen[id] = true
+	}
+	for len(seen) > 0 {
+		var event client.Event
+		select {
+		case event = <-watcher.Events():
+		case <-ctx.Done():
+			t.Fatalf("%d creations not pushed: %v", len(seen), ctx.Err())
+		}
+		if event.Event != server.EventCreated || !seen[event.DataID] {
+			t.Fatalf("unexpected event %+v", event)
+		}
+		delete(seen, event.DataID)
+	}
+
+	list, _, err := watcher.List(ctx, model.TradingSystemFilter{Limit: clients * perClient})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(list) != clients*perClient {
+		t.Fatalf("List returned %d trading systems, want %d", len(list), clients*perClient)
+	}
+
+	stats := s.Handler.Stats()
+	if stats.Connections != clients+1 || stats.DroppedMessages != 0 || stats.WriteErrors != 0 {
+		t.Fatalf("got stats %+v, want %d connections and no lost messages", stats, clients+1)
+	}
 }
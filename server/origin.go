@@ -0,0 +1,128 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/chidi150c/database/model"
+)
+
+// DefaultDevOrigins are the origins allowed in the dev environment when no
+// list is configured: dashboards served from the developer's machine.
+const DefaultDevOrigins = "http://localhost:*,http://127.0.0.1:*"
+
+// OriginPolicy is the allowlist of the origins browsers may connect from.
+// Requests without an Origin header do not come from a browser page, so they
+// are always allowed.
+type OriginPolicy struct {
+	patterns []originPattern
+}
+
+// originPattern matches origins. A host starting with "*." matches every
+// subdomain of the rest of it, but not the domain itself, and the port "*"
+// matches any port.
+type originPattern struct {
+	scheme string
+	host   string
+	port   string
+	any    bool
+}
+
+// NewOriginPolicy builds an OriginPolicy from patterns such as
+// "https://example.com", "https://*.example.com" or "http://localhost:*".
+// The pattern "*" allows every origin. An empty list allows no browser
+// origin.
+func NewOriginPolicy(patterns []string) (*OriginPolicy, error) {
+	p := &OriginPolicy{}
+	for _, s := range patterns {
+		if s == "*" {
+			p.patterns = append(p.patterns, originPattern{any: true})
+			continue
+		}
+		// url.Parse refuses the "*" port, so the pattern is split by hand
+		scheme, host, ok := strings.Cut(strings.TrimSuffix(s, "/"), "://")
+		if !ok || scheme == "" || host == "" || strings.ContainsAny(host, "/?#@") {
+			return nil, fmt.Errorf("invalid origin %q, want scheme://host[:port]", s)
+		}
+		port := ""
+		if i := strings.LastIndex(host, ":"); i >= 0 && !strings.HasSuffix(host, "]") {
+			host, port = host[:i], host[i+1:]
+		}
+		scheme = strings.ToLower(scheme)
+		p.patterns = append(p.patterns, originPattern{
+			scheme: scheme,
+			host:   strings.ToLower(strings.Trim(host, "[]")),
+			port:   withoutDefaultPort(scheme, port),
+		})
+	}
+	return p, nil
+}
+
+// defaultPorts are the ports an origin may leave out.
+var defaultPorts = map[string]string{"http": "80", "https": "443"}
+
+// withoutDefaultPort returns port, or "" if it is the default port of scheme,
+// so that https://example.com:443 and https://example.com are one origin.
+func withoutDefaultPort(scheme, port string) string {
+	if defaultPorts[scheme] == port {
+		return ""
+	}
+	return port
+}
+
+// SplitOrigins splits a comma or space separated list of origins.
+func SplitOrigins(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' })
+}
+
+// Allowed reports whether a request with the given Origin header may be
+// served.
+func (p *OriginPolicy) Allowed(origin string) bool {
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	scheme, host := strings.ToLower(u.Scheme), strings.ToLower(u.Hostname())
+	port := withoutDefaultPort(scheme, u.Port())
+	for _, pat := range p.patterns {
+		if pat.any {
+			return true
+		}
+		if pat.scheme != scheme || (pat.port != "*" && pat.port != port) {
+			continue
+		}
+		if pat.host == host {
+			return true
+		}
+		if strings.HasPrefix(pat.host, "*.") && strings.HasSuffix(host, pat.host[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOrigin refuses requests from browser pages on origins the handler
+// does not allow. Every origin is allowed when the handler has no policy.
+func (th *TradeHandler) checkOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if th.Origins == nil || th.Origins.Allowed(origin) {
+		return nil
+	}
+	return model.Errorf(model.CodeForbidden, "Origin %q is not allowed", origin)
+}
+
+// requireOrigin is the middleware applying checkOrigin to the REST routes.
+func (th *TradeHandler) requireOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := th.checkOrigin(r); err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
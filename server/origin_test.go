@@ -0,0 +1,56 @@
+package server
+
+import "testing"
+
+func TestOriginPolicy(t *testing.T) {
+	p, err := NewOriginPolicy([]string{"https://resoledge.com", "https://*.resoledge.com", "http://localhost:*", "http://127.0.0.1:3000", "https://api.example.com:443", "http://example.com:80"})
+	if err != nil {
+		t.Fatalf("NewOriginPolicy: %v", err)
+	}
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"", true},
+		{"https://resoledge.com", true},
+		{"https://RESOLEDGE.com", true},
+		{"https://app.resoledge.com", true},
+		{"https://a.b.resoledge.com", true},
+		{"http://resoledge.com", false},
+		{"https://resoledge.com:8443", false},
+		{"https://evilresoledge.com", false},
+		{"https://resoledge.com.evil.com", false},
+		{"http://localhost:5173", true},
+		{"http://localhost", true},
+		{"http://127.0.0.1:3000", true},
+		{"http://127.0.0.1:3001", false},
+		{"null", false},
+		// The default port of the scheme is the same as no port
+		{"https://resoledge.com:443", true},
+		{"https://app.resoledge.com:443", true},
+		{"http://resoledge.com:443", false},
+		{"https://api.example.com", true},
+		{"https://api.example.com:443", true},
+		{"http://example.com", true},
+		{"http://example.com:80", true},
+		{"https://example.com", false},
+		{"http://example.com:8080", false},
+	}
+	for _, tt := range tests {
+		if got := p.Allowed(tt.origin); got != tt.want {
+			t.Errorf("Allowed(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+
+	if empty, _ := NewOriginPolicy(nil); empty.Allowed("https://resoledge.com") || !empty.Allowed("") {
+		t.Error("an empty policy must refuse every browser origin and allow requests without one")
+	}
+	if all, _ := NewOriginPolicy([]string{"*"}); !all.Allowed("https://anything.example") {
+		t.Error(`"*" must allow every origin`)
+	}
+	for _, bad := range []string{"resoledge.com", "https://", "https://resoledge.com/app"} {
+		if _, err := NewOriginPolicy([]string{bad}); err == nil {
+			t.Errorf("NewOriginPolicy(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chidi150c/database/model"
+	"github.com/go-chi/chi"
+)
+
+// tradingSystemRoutes registers the REST routes of the trading-system entity.
+// Responses use the same envelope as the WebSocket protocol, without the
+// request ID, and the HTTP status reflects the error code. Writes to a
+// trading system go through the dispatcher of the WebSocket operations, so
+// they are ordered with the ones on the same trading system.
+func (th *TradeHandler) tradingSystemRoutes(r chi.Router) {
+	r.Use(th.requireKey)
+	r.Get("/", th.handleListTradingSystems)
+	r.Post("/", th.handleCreateTradingSystem)
+	r.Get("/{id}", th.handleReadTradingSystem)
+	r.Put("/{id}", th.handleUpdateTradingSystem)
+	r.Patch("/{id}", th.handlePatchTradingSystem)
+	r.Delete("/{id}", th.handleDeleteTradingSystem)
+	r.Get("/{id}/history", th.handleListHistory)
+	r.Post("/{id}/revert", th.handleRevertTradingSystem)
+}
+
+// handleListTradingSystems takes the fields of model.TradingSystemFilter as
+// query parameters, with times in RFC 3339 format.
+func (th *TradeHandler) handleListTradingSystems(w http.ResponseWriter, r *http.Request) {
+	filter, err := listFilterParams(r)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	data, next, err := th.listTradingSystems(filter)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeHTTPResponse(w, http.StatusOK, &model.Response{Status: model.StatusOK, Data: data, NextCursor: next})
+}
+
+func (th *TradeHandler) handleCreateTradingSystem(w http.ResponseWriter, r *http.Request) {
+	var ts model.TradingSystemData
+	if err := json.NewDecoder(r.Body).Decode(&ts); err != nil {
+		writeHTTPError(w, model.Errorf(model.CodeInvalidPayload, "Error parsing trading system: %v", err))
+		return
+	}
+	dbTrade, err := th.createTradingSystem(httpClient(r), &ts)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeHTTPResponse(w, http.StatusCreated, &model.Response{
+		Status:   model.StatusOK,
+		Message:  "TradingSystem Created successfully",
+		DataID:   dbTrade.ID,
+		Revision: dbTrade.Revision,
+		Data:     dbTrade.Data(),
+	})
+}
+
+// handleReadTradingSystem reads an earlier version of the trading system when
+// given the revision or as_of (RFC 3339) query parameter.
+func (th *TradeHandler) handleReadTradingSystem(w http.ResponseWriter, r *http.Request) {
+	tradeID, err := tradeIDParam(r)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	req := ReadRequest{ID: tradeID}
+	q := r.URL.Query()
+	if q.Has("revision") {
+		revision, err := strconv.ParseUint(q.Get("revision"), 10, 64)
+		if err != nil {
+			writeHTTPError(w, model.Errorf(model.CodeInvalidPayload, "Invalid revision %q", q.Get("revision")))
+			return
+		}
+		req.Revision = uint(revision)
+	}
+	if q.Has("as_of") {
+		asOf, err := time.Parse(time.RFC3339, q.Get("as_of"))
+		if err != nil {
+			writeHTTPError(w, model.Errorf(model.CodeInvalidPayload, "Invalid as_of %q", q.Get("as_of")))
+			return
+		}
+		req.AsOf = &asOf
+	}
+	dbTrade, err := th.readTradingSystem(req)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeHTTPResponse(w, http.StatusOK, &model.Response{Status: model.StatusOK, DataID: dbTrade.ID, Data: dbTrade.Data()})
+}
+
+func (th *TradeHandler) handleUpdateTradingSystem(w http.ResponseWriter, r *http.Request) {
+	tradeID, err := tradeIDParam(r)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	var ts model.TradingSystemData
+	if err := json.NewDecoder(r.Body).Decode(&ts); err != nil {
+		writeHTTPError(w, model.Errorf(model.CodeInvalidPayload, "Error parsing trading system: %v", err))
+		return
+	}
+	ts.ID = tradeID
+	var dbTrade *model.TradingSystem
+	err = th.serialize(tradeID, func() (err error) {
+		dbTrade, err = th.updateTradingSystem(httpClient(r), &ts)
+		return err
+	})
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeHTTPResponse(w, http.StatusOK, &model.Response{
+		Status:   model.StatusOK,
+		Message:  "Trading system updated successfully",
+		DataID:   dbTrade.ID,
+		Revision: dbTrade.Revision,
+		Data:     dbTrade.Data(),
+	})
+}
+
+func (th *TradeHandler) handlePatchTradingSystem(w http.ResponseWriter, r *http.Request) {
+	tradeID, err := tradeIDParam(r)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeHTTPError(w, model.Errorf(model.CodeInvalidPayload, "Error reading request body: %v", err))
+		return
+	}
+	var dbTrade *model.TradingSystem
+	err = th.serialize(tradeID, func() (err error) {
+		dbTrade, err = th.patchTradingSystem(httpClient(r), tradeID, patch)
+		return err
+	})
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeHTTPResponse(w, http.StatusOK, &model.Response{
+		Status:   model.StatusOK,
+		Message:  "Trading system updated successfully",
+		DataID:   dbTrade.ID,
+		Revision: dbTrade.Revision,
+		Data:     dbTrade.Data(),
+	})
+}
+
+// handleRevertTradingSystem takes a RevertRequest body; the ID comes from the
+// URL.
+func (th *TradeHandler) handleRevertTradingSystem(w http.ResponseWriter, r *http.Request) {
+	tradeID, err := tradeIDParam(r)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	var req RevertRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeHTTPError(w, model.Errorf(model.CodeInvalidPayload, "Error parsing revert request: %v", err))
+		return
+	}
+	var dbTrade *model.TradingSystem
+	err = th.serialize(tradeID, func() (err error) {
+		dbTrade, err = th.revertTradingSystem(httpClient(r), tradeID, req.Revision)
+		return err
+	})
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeHTTPResponse(w, http.StatusOK, &model.Response{
+		Status:   model.StatusOK,
+		Message:  "Trading system reverted successfully",
+		DataID:   dbTrade.ID,
+		Revision: dbTrade.Revision,
+		Data:     dbTrade.Data(),
+	})
+}
+
+func (th *TradeHandler) handleDeleteTradingSystem(w http.ResponseWriter, r *http.Request) {
+	tradeID, err := tradeIDParam(r)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	err = th.serialize(tradeID, func() error {
+		return th.deleteTradingSystem(httpClient(r), tradeID)
+	})
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeHTTPResponse(w, http.StatusOK, &model.Response{
+		Status:  model.StatusOK,
+		Message: "Trading system deleted successfully",
+		DataID:  tradeID,
+	})
+}
+
+// handleListHistory pages through the history of a trading system with the
+// limit and cursor query parameters.
+func (th *TradeHandler) handleListHistory(w http.ResponseWriter, r *http.Request) {
+	tradeID, err := tradeIDParam(r)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	query := model.HistoryQuery{ID: tradeID, Cursor: r.URL.Query().Get("cursor")}
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil {
+			writeHTTPError(w, model.Errorf(model.CodeInvalidPayload, "Invalid limit %q", limit))
+			return
+		}
+	}
+	entries, next, err := th.dbs.ListHistory(query)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeHTTPResponse(w, http.StatusOK, &model.Response{Status: model.StatusOK, Data: entries, NextCursor: next})
+}
+
+// httpClient identifies the client of an HTTP request in the history.
+func httpClient(r *http.Request) string {
+	return clientName("http:"+r.RemoteAddr, requestPrincipal(r))
+}
+
+// tradeIDParam parses the {id} URL parameter.
+func tradeIDParam(r *http.Request) (uint, error) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		return 0, model.Errorf(model.CodeInvalidPayload, "Invalid trading system ID %q", chi.URLParam(r, "id"))
+	}
+	return uint(id), nil
+}
+
+// listFilterParams parses the query parameters of the list route.
+func listFilterParams(r *http.Request) (model.TradingSystemFilter, error) {
+	q := r.URL.Query()
+	filter := model.TradingSystemFilter{
+		SortBy: q.Get("sort_by"),
+		Order:  q.Get("order"),
+		Cursor: q.Get("cursor"),
+	}
+	for name, dst := range map[string]**string{
+		"symbol":         &filter.Symbol,
+		"base_currency":  &filter.BaseCurrency,
+		"quote_currency": &filter.QuoteCurrency,
+	} {
+		if q.Has(name) {
+			v := q.Get(name)
+			*dst = &v
+		}
+	}
+	for name, dst := range map[string]**bool{
+		"in_trade":            &filter.InTrade,
+		"stop_loss_triggered": &filter.StopLossTrigered,
+	} {
+		if q.Has(name) {
+			v, err := strconv.ParseBool(q.Get(name))
+			if err != nil {
+				return filter, model.Errorf(model.CodeInvalidPayload, "Invalid %s %q", name, q.Get(name))
+			}
+			*dst = &v
+		}
+	}
+	for name, dst := range map[string]**time.Time{
+		"created_after":  &filter.CreatedAfter,
+		"created_before": &filter.CreatedBefore,
+		"updated_after":  &filter.UpdatedAfter,
+		"updated_before": &filter.UpdatedBefore,
+	} {
+		if q.Has(name) {
+			v, err := time.Parse(time.RFC3339, q.Get(name))
+			if err != nil {
+				return filter, model.Errorf(model.CodeInvalidPayload, "Invalid %s %q", name, q.Get(name))
+			}
+			*dst = &v
+		}
+	}
+	if q.Has("limit") {
+		limit, err := strconv.Atoi(q.Get("limit"))
+		if err != nil {
+			return filter, model.Errorf(model.CodeInvalidPayload, "Invalid limit %q", q.Get("limit"))
+		}
+		filter.Limit = limit
+	}
+	return filter, nil
+}
+
+// httpStatus maps an error code onto the matching HTTP status.
+func httpStatus(code string) int {
+	switch code {
+	case model.CodeNotFound:
+		return http.StatusNotFound
+	case model.CodeInvalidPayload, model.CodeUnknownAction, model.CodeUnknownEntity:
+		return http.StatusBadRequest
+	case model.CodeConflict:
+		return http.StatusConflict
+	case model.CodeUnauthorized:
+		return http.StatusUnauthorized
+	case model.CodeForbidden:
+		return http.StatusForbidden
+	case model.CodeUnavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeHTTPError(w http.ResponseWriter, err error) {
+	re := model.NewResponseError(err)
+	if re.Code == model.CodeInternal {
+		log.Printf("Error: while processing HTTP request: %v", err)
+	}
+	writeHTTPResponse(w, httpStatus(re.Code), &model.Response{
+		Status:  model.StatusError,
+		Message: re.Message,
+		Error:   re,
+	})
+}
+
+func writeHTTPResponse(w http.ResponseWriter, status int, response *model.Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println("Error sending HTTP response:", err)
+	}
+}
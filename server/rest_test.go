@@ -0,0 +1,252 @@
+package server_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chidi150c/database/model"
+	"github.com/chidi150c/database/servertest"
+)
+
+// restResponse is the envelope of a REST response, with the data left to be
+// decoded by each test.
+type restResponse struct {
+	Status     string               `json:"status"`
+	Message    string               `json:"message"`
+	DataID     uint                 `json:"data_id"`
+	Revision   uint                 `json:"revision"`
+	Data       json.RawMessage      `json:"data"`
+	NextCursor string               `json:"next_cursor"`
+	Error      *model.ResponseError `json:"error"`
+}
+
+// doREST sends a request to the trading-system routes of s and decodes the
+// response.
+func doREST(t *testing.T, s *servertest.Server, method, path, body string) (int, *restResponse) {
+	t.Helper()
+	req, err := http.NewRequest(method, s.URL+"/trading-systems"+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("%s %s returned Content-Type %q", method, path, ct)
+	}
+	var r restResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		t.Fatalf("%s %s returned an invalid body: %v", method, path, err)
+	}
+	return resp.StatusCode, &r
+}
+
+func decodeData(t *testing.T, r *restResponse, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(r.Data, v); err != nil {
+		t.Fatalf("invalid data %s: %v", r.Data, err)
+	}
+}
+
+// wantTradingSystem checks the trading system in the data of r.
+func wantTradingSystem(revision uint, quoteBalance float64, baseCurrency string) func(t *testing.T, r *restResponse) {
+	return func(t *testing.T, r *restResponse) {
+		t.Helper()
+		var ts model.TradingSystemData
+		decodeData(t, r, &ts)
+		if ts.Symbol != "BTC" || ts.Revision != revision || ts.QuoteBalance != quoteBalance || ts.BaseCurrency != baseCurrency {
+			t.Fatalf("got %s at revision %d with quote_balance %v and base_currency %q, want BTC at %d with %v and %q",
+				ts.Symbol, ts.Revision, ts.QuoteBalance, ts.BaseCurrency, revision, quoteBalance, baseCurrency)
+		}
+	}
+}
+
+// wantHistory checks the revisions of the history entries in the data of r
+// and whether a following page is announced.
+func wantHistory(next bool, revisions ...uint) func(t *testing.T, r *restResponse) {
+	return func(t *testing.T, r *restResponse) {
+		t.Helper()
+		var entries []*model.TradingSystemHistory
+		decodeData(t, r, &entries)
+		var got []uint
+		for _, e := range entries {
+			got = append(got, e.Revision)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(revisions) || (r.NextCursor != "") != next {
+			t.Fatalf("got revisions %v and cursor %q, want %v and a cursor %v", got, r.NextCursor, revisions, next)
+		}
+	}
+}
+
+func TestRESTTradingSystem(t *testing.T) {
+	s := servertest.NewServer(t)
+	id, err := s.DBS.CreateTradingSystem(model.NewTradingSystem(&model.TradingSystemData{
+		Symbol:       "BTC",
+		BaseCurrency: "BTC",
+		QuoteBalance: 100,
+	}))
+	if err != nil {
+		t.Fatalf("CreateTradingSystem: %v", err)
+	}
+	path := fmt.Sprintf("/%d", id)
+
+	// The steps run in order against the same trading system.
+	steps := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantCode   string
+		check      func(t *testing.T, r *restResponse)
+	}{
+		{"read", http.MethodGet, path, "", http.StatusOK, "", wantTradingSystem(1, 100, "BTC")},
+		{"read invalid ID", http.MethodGet, "/abc", "", http.StatusBadRequest, model.CodeInvalidPayload, nil},
+		{"read unknown ID", http.MethodGet, "/999", "", http.StatusNotFound, model.CodeNotFound, nil},
+		{"create", http.MethodPost, "/", `{"symbol": "ETH"}`, http.StatusCreated, "", func(t *testing.T, r *restResponse) {
+			if r.DataID == 0 || r.DataID == id || r.Revision != 1 {
+				t.Fatalf("created ID %d at revision %d, want a new ID at revision 1", r.DataID, r.Revision)
+			}
+		}},
+		{"create malformed", http.MethodPost, "/", `{"symbol":`, http.StatusBadRequest, model.CodeInvalidPayload, nil},
+		{"create invalid", http.MethodPost, "/", `{"symbol": ""}`, http.StatusBadRequest, model.CodeInvalidPayload, nil},
+		// PUT replaces every field: base_currency, left out, is cleared.
+		{"put", http.MethodPut, path, `{"symbol": "BTC", "quote_balance": 90, "revision": 1}`, http.StatusOK, "", wantTradingSystem(2, 90, "")},
+		{"put stale revision", http.MethodPut, path, `{"symbol": "BTC", "revision": 1}`, http.StatusConflict, model.CodeConflict, func(t *testing.T, r *restResponse) {
+			if r.Error.CurrentRevision != 2 {
+				t.Fatalf("conflict reported current revision %d, want 2", r.Error.CurrentRevision)
+			}
+		}},
+		{"put invalid", http.MethodPut, path, `{"symbol": ""}`, http.StatusBadRequest, model.CodeInvalidPayload, nil},
+		{"put unknown ID", http.MethodPut, "/999", `{"symbol": "BTC"}`, http.StatusNotFound, model.CodeNotFound, nil},
+		// PATCH only changes the fields given.
+		{"patch", http.MethodPatch, path, `{"base_currency": "XBT"}`, http.StatusOK, "", wantTradingSystem(3, 90, "XBT")},
+		{"patch again", http.MethodPatch, path, `{"quote_balance": 80}`, http.StatusOK, "", wantTradingSystem(4, 80, "XBT")},
+		{"patch stale revision", http.MethodPatch, path, `{"quote_balance": 1, "revision": 2}`, http.StatusConflict, model.CodeConflict, nil},
+		{"patch malformed", http.MethodPatch, path, `{"quote_balance":`, http.StatusBadRequest, model.CodeInvalidPayload, nil},
+		{"patch unknown ID", http.MethodPatch, "/999", `{"quote_balance": 1}`, http.StatusNotFound, model.CodeNotFound, nil},
+		{"read revision", http.MethodGet, path + "?revision=1", "", http.StatusOK, "", wantTradingSystem(1, 100, "BTC")},
+		{"read unknown revision", http.MethodGet, path + "?revision=9", "", http.StatusNotFound, model.CodeNotFound, nil},
+		{"read invalid revision", http.MethodGet, path + "?revision=first", "", http.StatusBadRequest, model.CodeInvalidPayload, nil},
+		{"read as of", http.MethodGet, path + "?as_of=" + url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339)), "", http.StatusOK, "", wantTradingSystem(4, 80, "XBT")},
+		{"read invalid as of", http.MethodGet, path + "?as_of=yesterday", "", http.StatusBadRequest, model.CodeInvalidPayload, nil},
+		{"history", http.MethodGet, path + "/history?limit=3", "", http.StatusOK, "", wantHistory(true, 1, 2, 3)},
+		{"history next page", http.MethodGet, path + "/history?cursor=3", "", http.StatusOK, "", wantHistory(false, 4)},
+		{"history invalid limit", http.MethodGet, path + "/history?limit=all", "", http.StatusBadRequest, model.CodeInvalidPayload, nil},
+		{"history unknown ID", http.MethodGet, "/999/history", "", http.StatusNotFound, model.CodeNotFound, nil},
+		{"revert", http.MethodPost, path + "/revert", `{"revision": 1}`, http.StatusOK, "", wantTradingSystem(5, 100, "BTC")},
+		{"revert unknown revision", http.MethodPost, path + "/revert", `{"revision": 9}`, http.StatusNotFound, model.CodeNotFound, nil},
+		{"revert malformed", http.MethodPost, path + "/revert", `{"revision":`, http.StatusBadRequest, model.CodeInvalidPayload, nil},
+		{"history after revert", http.MethodGet, path + "/history?cursor=4", "", http.StatusOK, "", wantHistory(false, 5)},
+		{"delete", http.MethodDelete, path, "", http.StatusOK, "", func(t *testing.T, r *restResponse) {
+			if r.DataID != id {
+				t.Fatalf("deleted ID %d, want %d", r.DataID, id)
+			}
+		}},
+		{"read deleted", http.MethodGet, path, "", http.StatusNotFound, model.CodeNotFound, nil},
+		{"delete again", http.MethodDelete, path, "", http.StatusNotFound, model.CodeNotFound, nil},
+		{"delete invalid ID", http.MethodDelete, "/abc", "", http.StatusBadRequest, model.CodeInvalidPayload, nil},
+	}
+	for _, step := range steps {
+		status, r := doREST(t, s, step.method, step.path, step.body)
+		if status != step.wantStatus {
+			t.Fatalf("%s: got status %d (%+v), want %d", step.name, status, r.Error, step.wantStatus)
+		}
+		if step.wantCode != "" {
+			if r.Status != model.StatusError || r.Error == nil || r.Error.Code != step.wantCode {
+				t.Fatalf("%s: got status %q and error %+v, want code %q", step.name, r.Status, r.Error, step.wantCode)
+			}
+		} else if r.Status != model.StatusOK || r.Error != nil {
+			t.Fatalf("%s: got status %q and error %+v, want ok", step.name, r.Status, r.Error)
+		}
+		if step.check != nil {
+			t.Run(step.name, func(t *testing.T) { step.check(t, r) })
+		}
+	}
+}
+
+func TestRESTList(t *testing.T) {
+	s := servertest.NewServer(t)
+	var ids []uint
+	for _, ts := range []*model.TradingSystemData{
+		{Symbol: "BTC", QuoteBalance: 10},
+		{Symbol: "BTC", QuoteBalance: 30},
+		{Symbol: "ETH", QuoteBalance: 20, InTrade: true},
+	} {
+		id, err := s.DBS.CreateTradingSystem(model.NewTradingSystem(ts))
+		if err != nil {
+			t.Fatalf("CreateTradingSystem: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	listed := func(t *testing.T, r *restResponse) []uint {
+		t.Helper()
+		var trades []*model.TradingSystemData
+		decodeData(t, r, &trades)
+		got := []uint{}
+		for _, ts := range trades {
+			got = append(got, ts.ID)
+		}
+		return got
+	}
+	future := url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339))
+
+	tests := []struct {
+		query    string
+		want     []int // indexes of ids, in order
+		wantNext bool
+		wantCode string
+	}{
+		{query: "", want: []int{0, 1, 2}},
+		{query: "symbol=BTC", want: []int{0, 1}},
+		{query: "symbol=DOGE", want: []int{}},
+		{query: "in_trade=true", want: []int{2}},
+		{query: "in_trade=false&symbol=BTC", want: []int{0, 1}},
+		{query: "sort_by=quote_balance&order=desc", want: []int{1, 2, 0}},
+		{query: "sort_by=quote_balance&order=asc&limit=2", want: []int{0, 2}, wantNext: true},
+		{query: "limit=2", want: []int{0, 1}, wantNext: true},
+		{query: "created_before=" + future, want: []int{0, 1, 2}},
+		{query: "created_after=" + future, want: []int{}},
+		{query: "in_trade=maybe", wantCode: model.CodeInvalidPayload},
+		{query: "created_after=yesterday", wantCode: model.CodeInvalidPayload},
+		{query: "limit=many", wantCode: model.CodeInvalidPayload},
+		{query: "sort_by=nope", wantCode: model.CodeInvalidPayload},
+		{query: "order=sideways", wantCode: model.CodeInvalidPayload},
+		{query: "cursor=garbage", wantCode: model.CodeInvalidPayload},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			status, r := doREST(t, s, http.MethodGet, "/?"+tt.query, "")
+			if tt.wantCode != "" {
+				if status != http.StatusBadRequest || r.Error == nil || r.Error.Code != tt.wantCode {
+					t.Fatalf("got status %d and error %+v, want %d and code %q", status, r.Error, http.StatusBadRequest, tt.wantCode)
+				}
+				return
+			}
+			if status != http.StatusOK {
+				t.Fatalf("got status %d (%+v), want %d", status, r.Error, http.StatusOK)
+			}
+			want := []uint{}
+			for _, i := range tt.want {
+				want = append(want, ids[i])
+			}
+			if got := listed(t, r); fmt.Sprint(got) != fmt.Sprint(want) || (r.NextCursor != "") != tt.wantNext {
+				t.Fatalf("listed %v with cursor %q, want %v and a cursor %v", got, r.NextCursor, want, tt.wantNext)
+			}
+		})
+	}
+
+	// The cursor carries on with the same filter and sort order.
+	_, r := doREST(t, s, http.MethodGet, "/?sort_by=quote_balance&order=desc&limit=2", "")
+	_, r = doREST(t, s, http.MethodGet, "/?sort_by=quote_balance&order=desc&limit=2&cursor="+url.QueryEscape(r.NextCursor), "")
+	if got := listed(t, r); len(got) != 1 || got[0] != ids[0] || r.NextCursor != "" {
+		t.Fatalf("second page listed %v with cursor %q, want [%d] and no cursor", got, r.NextCursor, ids[0])
+	}
+}
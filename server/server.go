@@ -1,22 +1,33 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"log"
 	"net"
 	"net/http"
+	"sync"
 )
 
 //Server, handles the opening and closing of an HTTP server using the net/http and gorilla/handlers packages. 
 //it holds the TradeHandler type which 
 type Server struct {
+	// Listener is opened on Port by Open unless it is set beforehand.
 	Listener net.Listener
 	// Handler to serve http.
-	HttpHandler TradeHandler
+	HttpHandler *TradeHandler
 	// Bind address to open for http.
 	Port string
+	// TLS serves HTTPS, and WSS, when set.
+	TLS *TLSConfig
+
+	mu    sync.Mutex
+	http  *http.Server
+	certs *certReloader
 }
 
-func NewServer(port string, th TradeHandler) *Server {
+func NewServer(port string, th *TradeHandler) *Server {
 	return &Server{
 		HttpHandler:  th,
 		Port:        ":" + port,
@@ -27,22 +38,72 @@ func NewServer(port string, th TradeHandler) *Server {
 //from the gorilla/handlers package. 
 func (s *Server) Open() (err error) {	
     log.Println("Opening server...")
-	s.Listener, err = net.Listen("tcp", s.Port)
-    if err != nil {
-        log.Fatalf("Error while opening listener: %v", err)
-        return err
-    }
-
-    log.Printf("Server listening on %s", s.Port)
+	var certs *certReloader
+	if s.TLS != nil {
+		if certs, err = newCertReloader(*s.TLS); err != nil {
+			log.Printf("Error while loading TLS certificates: %v", err)
+			return err
+		}
+	}
+	if s.Listener == nil {
+		s.Listener, err = net.Listen("tcp", s.Port)
+		if err != nil {
+			log.Printf("Error while opening listener: %v", err)
+			return err
+		}
+	}
+	if certs != nil {
+		s.mu.Lock()
+		s.certs = certs
+		s.mu.Unlock()
+		s.Listener = tls.NewListener(s.Listener, certs.config())
+		log.Printf("Server listening with TLS on %s", s.Listener.Addr())
+	} else {
+		log.Printf("Server listening on %s", s.Listener.Addr())
+	}
 
     // Start serving
 	// log.Fatal(http.Serve(s.Listener, handlers.CombinedLoggingHandler(os.Stderr, s.HttpHandler)))
-	log.Fatal(http.Serve(s.Listener, s.HttpHandler))
+	if err := s.httpServer().Serve(s.Listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
 	return nil
 }
 
+func (s *Server) httpServer() *http.Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.http == nil {
+		s.http = &http.Server{Handler: s.HttpHandler}
+	}
+	return s.http
+}
+
+// Shutdown stops the server gracefully: it stops accepting connections,
+// waits for the REST requests in flight, then drains the WebSocket
+// connections with TradeHandler.Shutdown. Open returns nil once it is
+// called. Whatever is still running when ctx is done is cut off.
+func (s *Server) Shutdown(ctx context.Context) error {
+	log.Println("Shutting down server...")
+	err := s.httpServer().Shutdown(ctx)
+	if herr := s.HttpHandler.Shutdown(ctx); err == nil {
+		err = herr
+	}
+	s.mu.Lock()
+	if s.certs != nil {
+		s.certs.close()
+	}
+	s.mu.Unlock()
+	return err
+}
+
 //Close method is responsible for closing the server's socket.
 func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.certs != nil {
+		s.certs.close()
+	}
+	s.mu.Unlock()
 	if s.Listener != nil {
 		s.Listener.Close()
 	}
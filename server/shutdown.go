@@ -0,0 +1,37 @@
+package server
+
+import (
+	"context"
+)
+
+// Shutdown drains the WebSocket connections: new connections and messages
+// are refused, the operations in flight are waited for until ctx is done,
+// so that their replies are still sent, and every client is then sent a
+// close frame. The connections are closed when it returns, with the error
+// of ctx if operations were still running.
+func (th *TradeHandler) Shutdown(ctx context.Context) error {
+	th.draining.Store(true)
+	d := th.dispatcher()
+	d.close()
+	err := d.wait(ctx)
+
+	th.connections.RLock()
+	conns := make([]*wsConn, 0, len(th.connections.m))
+	for c := range th.connections.m {
+		conns = append(conns, c)
+		c.out.goAway()
+	}
+	th.connections.RUnlock()
+	for _, c := range conns {
+		// Let the writer flush the replies and the close frame first.
+		select {
+		case <-c.out.stopped:
+		case <-ctx.Done():
+		}
+		c.out.close()
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,135 @@
+package server_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/chidi150c/database/memory"
+	"github.com/chidi150c/database/model"
+	"github.com/chidi150c/database/server"
+	"github.com/gorilla/websocket"
+)
+
+func TestShutdown(t *testing.T) {
+	th := server.NewTradeHandler(memory.NewDBServices())
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &server.Server{Listener: ln, HttpHandler: th}
+	served := make(chan error, 1)
+	go func() { served <- srv.Open() }()
+	url := "ws://" + ln.Addr().String() + "/database-services/ws"
+
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	if err := ws.WriteJSON(server.WebSocketMessage{RequestID: "1", Action: "create", Entity: "trading-system", Data: map[string]interface{}{"Symbol": "BTCUSDT"}}); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	var resp model.Response
+	if err := ws.ReadJSON(&resp); err != nil || resp.Status != model.StatusOK {
+		t.Fatalf("create returned %+v, %v", resp, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- srv.Shutdown(ctx) }()
+
+	// The client is told the server is going away.
+	_, _, err = ws.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Fatalf("ReadMessage returned %v, want a going away close frame", err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("Open returned %v after Shutdown", err)
+	}
+	if _, _, err := websocket.DefaultDialer.Dial(url, nil); err == nil {
+		t.Fatal("Dial succeeded after Shutdown")
+	}
+}
+
+// slowAppends holds every AppendPrices until release is closed.
+type slowAppends struct {
+	model.DBServicer
+	started chan struct{}
+	release chan struct{}
+}
+
+func (db *slowAppends) WithClient(client string) model.DBServicer {
+	return &slowAppends{db.DBServicer.WithClient(client), db.started, db.release}
+}
+
+func (db *slowAppends) AppendPrices(tradeID uint, points []model.PricePoint) (*model.TradingSystem, error) {
+	db.started <- struct{}{}
+	<-db.release
+	return db.DBServicer.AppendPrices(tradeID, points)
+}
+
+func TestShutdownWaitsForOperations(t *testing.T) {
+	db := &slowAppends{memory.NewDBServices(), make(chan struct{}, 1), make(chan struct{})}
+	th := server.NewTradeHandler(db)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &server.Server{Listener: ln, HttpHandler: th}
+	go srv.Open()
+	ws, _, err := websocket.DefaultDialer.Dial("ws://"+ln.Addr().String()+"/database-services/ws", nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	send := func(id, action string, data map[string]interface{}) {
+		t.Helper()
+		if err := ws.WriteJSON(server.WebSocketMessage{RequestID: id, Action: action, Entity: "trading-system", Data: data}); err != nil {
+			t.Fatalf("WriteJSON: %v", err)
+		}
+	}
+	send("create", "create", map[string]interface{}{"Symbol": "BTCUSDT"})
+	var resp model.Response
+	if err := ws.ReadJSON(&resp); err != nil || resp.Status != model.StatusOK {
+		t.Fatalf("create returned %+v, %v", resp, err)
+	}
+	send("append", "append-price", map[string]interface{}{"id": resp.DataID, "points": []model.PricePoint{{Timestamp: 1, Price: 100}}})
+	<-db.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- srv.Shutdown(ctx) }()
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned %v with an operation in flight", err)
+	default:
+	}
+	// Messages received from now on are refused.
+	send("read", "read", map[string]interface{}{"id": resp.DataID})
+	resp = model.Response{}
+	if err := ws.ReadJSON(&resp); err != nil || resp.RequestID != "read" || resp.Error == nil || resp.Error.Code != "unavailable" {
+		t.Fatalf("read during shutdown returned %+v, %v", resp, err)
+	}
+
+	// The operation in flight completes and its reply comes before the
+	// close frame.
+	close(db.release)
+	resp = model.Response{}
+	if err := ws.ReadJSON(&resp); err != nil || resp.RequestID != "append" || resp.Status != model.StatusOK {
+		t.Fatalf("append-price in flight returned %+v, %v", resp, err)
+	}
+	if _, _, err := ws.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Fatalf("ReadMessage returned %v, want a going away close frame", err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+}
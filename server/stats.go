@@ -0,0 +1,57 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// Stats is a snapshot of the WebSocket connections and operations of a
+// TradeHandler.
+type Stats struct {
+	Connections int `json:"connections"`
+	// QueuedMessages is the number of messages waiting in the outbound
+	// queues of the connections.
+	QueuedMessages int `json:"queued_messages"`
+	// DroppedMessages counts the messages discarded because an outbound
+	// queue was full.
+	DroppedMessages uint64 `json:"dropped_messages"`
+	// SlowDisconnects counts the connections closed because their outbound
+	// queue was full.
+	SlowDisconnects uint64 `json:"slow_disconnects"`
+	// WriteErrors counts the failed writes, including write timeouts.
+	WriteErrors uint64 `json:"write_errors"`
+
+	// QueuedOperations is the number of received messages waiting to be
+	// processed, either for a free slot or behind an earlier operation on
+	// the same trading system.
+	QueuedOperations int `json:"queued_operations"`
+	// RunningOperations is the number of messages being processed.
+	RunningOperations int `json:"running_operations"`
+	// LongestQueue is the number of operations waiting on the busiest
+	// trading system.
+	LongestQueue int `json:"longest_queue"`
+}
+
+// Stats returns the current Stats of th.
+func (th *TradeHandler) Stats() Stats {
+	th.connections.RLock()
+	stats := Stats{Connections: len(th.connections.m)}
+	for c := range th.connections.m {
+		stats.QueuedMessages += c.out.queued()
+	}
+	th.connections.RUnlock()
+	stats.DroppedMessages = th.writerStats.dropped.Load()
+	stats.SlowDisconnects = th.writerStats.disconnects.Load()
+	stats.WriteErrors = th.writerStats.writeErrors.Load()
+	stats.QueuedOperations, stats.RunningOperations, stats.LongestQueue = th.dispatcher().stats()
+	return stats
+}
+
+// handleStats serves GET /database-services/stats.
+func (th *TradeHandler) handleStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(th.Stats()); err != nil {
+		log.Println("Error writing stats:", err)
+	}
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chidi150c/database/model"
+)
+
+// DefaultReloadInterval is how often the certificate files are checked for
+// changes when TLSConfig.ReloadInterval is not set.
+const DefaultReloadInterval = 30 * time.Second
+
+// TLSConfig configures the TLS listener of a Server. When ClientCAFile is set
+// the client certificates are verified against its CA bundle, and the
+// identity of a verified certificate is granted the scopes of
+// TradeHandler.CertScopes.
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile is the PEM bundle of the CAs client certificates are
+	// verified against. Client certificates are not requested without it.
+	ClientCAFile string
+	// RequireClientCert refuses the connections that present no valid
+	// client certificate. Otherwise they may still authenticate with an API
+	// key.
+	RequireClientCert bool
+	// ReloadInterval is how often the files are checked for changes.
+	ReloadInterval time.Duration
+}
+
+// certReloader holds the certificate and client CAs of a TLSConfig and
+// reloads them when their files change, so that renewed certificates are
+// served without a restart.
+type certReloader struct {
+	cfg TLSConfig
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+	stamps   []fileStamp
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// fileStamp tells whether a file changed since it was loaded.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func newCertReloader(cfg TLSConfig) (*certReloader, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, errors.New("TLS needs a certificate and a key file")
+	}
+	if cfg.RequireClientCert && cfg.ClientCAFile == "" {
+		return nil, errors.New("requiring client certificates needs a client CA file")
+	}
+	if cfg.ReloadInterval <= 0 {
+		cfg.ReloadInterval = DefaultReloadInterval
+	}
+	r := &certReloader{cfg: cfg, stop: make(chan struct{})}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	go r.watch()
+	return r, nil
+}
+
+func (r *certReloader) files() []string {
+	files := []string{r.cfg.CertFile, r.cfg.KeyFile}
+	if r.cfg.ClientCAFile != "" {
+		files = append(files, r.cfg.ClientCAFile)
+	}
+	return files
+}
+
+func (r *certReloader) statFiles() ([]fileStamp, error) {
+	var stamps []fileStamp
+	for _, name := range r.files() {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		stamps = append(stamps, fileStamp{modTime: fi.ModTime(), size: fi.Size()})
+	}
+	return stamps, nil
+}
+
+// load reads the files. The stamps are taken first, so that a file written
+// while it is read is loaded again on the next check.
+func (r *certReloader) load() error {
+	stamps, err := r.statFiles()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("loading TLS certificate: %v", err)
+	}
+	var pool *x509.CertPool
+	if r.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in client CA file %s", r.cfg.ClientCAFile)
+		}
+	}
+	r.mu.Lock()
+	r.cert, r.clientCA, r.stamps = &cert, pool, stamps
+	r.mu.Unlock()
+	return nil
+}
+
+// changed reports whether a file changed since the last successful load.
+func (r *certReloader) changed() bool {
+	stamps, err := r.statFiles()
+	if err != nil {
+		// A file being replaced may be missing for a moment.
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for i, s := range stamps {
+		if !s.modTime.Equal(r.stamps[i].modTime) || s.size != r.stamps[i].size {
+			return true
+		}
+	}
+	return false
+}
+
+// watch reloads the files whenever they change. A failed reload keeps the
+// previous certificates and is retried on the next check.
+func (r *certReloader) watch() {
+	ticker := time.NewTicker(r.cfg.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.load(); err != nil {
+				log.Printf("Error reloading TLS certificates, keeping the previous ones: %v", err)
+				continue
+			}
+			log.Printf("Reloaded TLS certificates from %s", r.cfg.CertFile)
+		}
+	}
+}
+
+func (r *certReloader) close() {
+	r.once.Do(func() { close(r.stop) })
+}
+
+// config returns the tls.Config of the listener. Every handshake uses the
+// certificates loaded last.
+func (r *certReloader) config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// WebSocket upgrades need HTTP/1.1.
+		NextProtos: []string{"http/1.1"},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				NextProtos:   []string{"http/1.1"},
+				Certificates: []tls.Certificate{*r.cert},
+			}
+			if r.clientCA != nil {
+				cfg.ClientCAs = r.clientCA
+				cfg.ClientAuth = tls.VerifyClientCertIfGiven
+				if r.cfg.RequireClientCert {
+					cfg.ClientAuth = tls.RequireAndVerifyClientCert
+				}
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// clientCertificate returns the verified client certificate of r, nil if it
+// presented none.
+func clientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// CertIdentity returns the identity of a client certificate: the common name
+// of its subject, or the whole subject when it has none.
+func CertIdentity(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	return cert.Subject.String()
+}
+
+// ParseCertScopes parses the scopes granted to client certificates, e.g.
+// "bot-1=read,write;dashboard=read;*=read", where "*" applies to the
+// identities not listed.
+func ParseCertScopes(s string) (map[string][]string, error) {
+	scopes := make(map[string][]string)
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, list, ok := strings.Cut(entry, "=")
+		id = strings.TrimSpace(id)
+		if !ok || id == "" {
+			return nil, fmt.Errorf("invalid certificate scopes %q, want identity=scope,...", entry)
+		}
+		var granted []string
+		for _, scope := range strings.Split(list, ",") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				granted = append(granted, scope)
+			}
+		}
+		if err := model.ValidateScopes(granted); err != nil {
+			return nil, fmt.Errorf("certificate identity %s: %v", id, err)
+		}
+		scopes[id] = granted
+	}
+	return scopes, nil
+}
@@ -0,0 +1,245 @@
+package server_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chidi150c/database/memory"
+	"github.com/chidi150c/database/model"
+	"github.com/chidi150c/database/server"
+	"github.com/gorilla/websocket"
+)
+
+// testCA issues the certificates of the TLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+var serial int64
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	ca := &testCA{}
+	ca.cert, ca.key, ca.pem = issue(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test CA"},
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}, nil)
+	return ca
+}
+
+// issue signs tmpl with ca, or self-signs it when ca is nil, and returns the
+// certificate, its key and its PEM encoding.
+func issue(t *testing.T, tmpl *x509.Certificate, ca *testCA) (*x509.Certificate, *ecdsa.PrivateKey, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial++
+	tmpl.SerialNumber = big.NewInt(serial)
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	parent, signer := tmpl, key
+	if ca != nil {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// writeServerCert issues a certificate for 127.0.0.1 named name and writes
+// it with its key to the given files.
+func (ca *testCA) writeServerCert(t *testing.T, name, certFile, keyFile string) {
+	t.Helper()
+	_, key, certPEM := issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	// The key goes first, so that a reload never pairs the new
+	// certificate with the old key.
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// clientCert issues a client certificate for the identity cn.
+func (ca *testCA) clientCert(t *testing.T, cn string) tls.Certificate {
+	t.Helper()
+	cert, key, _ := issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: cn},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+	return tls.Certificate{Certificate: [][]byte{cert.Raw}, PrivateKey: key}
+}
+
+func TestTLSClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.pem")
+	ca := newTestCA(t)
+	ca.writeServerCert(t, "server-1", certFile, keyFile)
+	if err := os.WriteFile(caFile, ca.pem, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	dbs := memory.NewDBServices()
+	th := server.NewTradeHandler(dbs)
+	scopes, err := server.ParseCertScopes("bot-1=read,write; *=read")
+	if err != nil {
+		t.Fatalf("ParseCertScopes: %v", err)
+	}
+	th.CertScopes = scopes
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &server.Server{
+		Listener:    ln,
+		HttpHandler: th,
+		TLS: &server.TLSConfig{
+			CertFile:       certFile,
+			KeyFile:        keyFile,
+			ClientCAFile:   caFile,
+			ReloadInterval: 10 * time.Millisecond,
+		},
+	}
+	// The listener is open already, so requests wait for Open to serve.
+	go srv.Open()
+	t.Cleanup(func() { srv.Close() })
+	addr := ln.Addr().String()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	httpClient := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs},
+		}}
+	}
+	do := func(c *http.Client, method, path, body string) int {
+		t.Helper()
+		req, err := http.NewRequest(method, "https://"+addr+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	bot := httpClient(ca.clientCert(t, "bot-1"))
+	other := httpClient(ca.clientCert(t, "dashboard"))
+	tests := []struct {
+		name   string
+		client *http.Client
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"no certificate", httpClient(), http.MethodGet, "/trading-systems/", "", http.StatusUnauthorized},
+		{"bot reads", bot, http.MethodGet, "/trading-systems/", "", http.StatusOK},
+		{"bot writes", bot, http.MethodPost, "/trading-systems/", `{"Symbol":"BTCUSDT"}`, http.StatusCreated},
+		{"bot lacks delete", bot, http.MethodDelete, "/trading-systems/1", "", http.StatusForbidden},
+		{"default scopes", other, http.MethodGet, "/trading-systems/", "", http.StatusOK},
+		{"default lacks write", other, http.MethodPost, "/trading-systems/", `{"Symbol":"ETHUSDT"}`, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := do(tt.client, tt.method, tt.path, tt.body); got != tt.want {
+				t.Fatalf("got status %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	// A certificate from another CA is refused during the handshake.
+	if _, err := httpClient(newTestCA(t).clientCert(t, "bot-1")).Get("https://" + addr + "/trading-systems/"); err == nil {
+		t.Fatal("request with a certificate from an unknown CA succeeded")
+	}
+
+	// Writes are attributed to the certificate identity in the history.
+	entries, _, err := dbs.ListHistory(model.HistoryQuery{ID: 1})
+	if err != nil || len(entries) != 1 || !strings.HasPrefix(entries[0].Client, "cert:bot-1/") {
+		t.Fatalf("ListHistory returned %+v, %v", entries, err)
+	}
+
+	// The WebSocket endpoint authenticates the same way.
+	dialer := &websocket.Dialer{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{ca.clientCert(t, "bot-1")}}}
+	ws, _, err := dialer.Dial("wss://"+addr+"/database-services/ws", nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	ws.Close()
+
+	// A renewed certificate is served without a restart.
+	time.Sleep(20 * time.Millisecond) // let the modification time move on
+	ca.writeServerCert(t, "server-2", certFile, keyFile)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: roots})
+		if err != nil {
+			t.Fatalf("tls.Dial: %v", err)
+		}
+		served := conn.ConnectionState().PeerCertificates[0]
+		conn.Close()
+		if served.Subject.CommonName == "server-2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("still serving %s after the certificate was replaced", served.Subject.CommonName)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestParseCertScopes(t *testing.T) {
+	scopes, err := server.ParseCertScopes("bot-1=read,write;*=read")
+	if err != nil {
+		t.Fatalf("ParseCertScopes: %v", err)
+	}
+	if got := strings.Join(scopes["bot-1"], ","); got != "read,write" {
+		t.Errorf("bot-1 scopes are %q", got)
+	}
+	if got := strings.Join(scopes["*"], ","); got != "read" {
+		t.Errorf("default scopes are %q", got)
+	}
+	for _, s := range []string{"bot-1", "=read", "bot-1=", "bot-1=root"} {
+		if _, err := server.ParseCertScopes(s); err == nil {
+			t.Errorf("ParseCertScopes(%q) succeeded", s)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"github.com/chidi150c/database/model"
+)
+
+// The methods below implement the trading-system operations shared by the
+// WebSocket actions and the REST routes: both transports decode their input,
+// call one of these and encode the result, so validation, storage, history and
+// change events behave the same whichever way a client comes in. Writes take
+// the identity of the client that made them, which the history records.
+
+// validateTradingSystem checks the fields every stored trading system must satisfy.
+func validateTradingSystem(ts *model.TradingSystemData) error {
+	if err := ts.Validate(); err != nil {
+		return model.Errorf(model.CodeInvalidPayload, "%v", err)
+	}
+	return nil
+}
+
+func (th *TradeHandler) createTradingSystem(client string, ts *model.TradingSystemData) (*model.TradingSystem, error) {
+	if err := validateTradingSystem(ts); err != nil {
+		return nil, err
+	}
+	// Convert standard types to custom data types
+	dbTrade := model.NewTradingSystem(ts)
+	// Insert the new trading system into the database
+	if _, err := th.dbs.WithClient(client).CreateTradingSystem(dbTrade); err != nil {
+		return nil, err
+	}
+	th.publish(EventCreated, dbTrade.Data())
+	return dbTrade, nil
+}
+
+// readTradingSystem reads the current trading system, or the version req
+// selects by revision or point in time.
+func (th *TradeHandler) readTradingSystem(req ReadRequest) (*model.TradingSystem, error) {
+	switch {
+	case req.Revision != 0 && req.AsOf != nil:
+		return nil, model.Errorf(model.CodeInvalidPayload, "Read either a revision or as_of, not both")
+	case req.Revision != 0:
+		return th.dbs.ReadTradingSystemRevision(req.ID, req.Revision)
+	case req.AsOf != nil:
+		return th.dbs.ReadTradingSystemAsOf(req.ID, *req.AsOf)
+	}
+	return th.dbs.ReadTradingSystem(req.ID)
+}
+
+func (th *TradeHandler) listTradingSystems(filter model.TradingSystemFilter) ([]*model.TradingSystemData, string, error) {
+	trades, next, err := th.dbs.ListTradingSystems(filter)
+	if err != nil {
+		return nil, "", err
+	}
+	data := make([]*model.TradingSystemData, 0, len(trades))
+	for _, t := range trades {
+		data = append(data, t.Data())
+	}
+	return data, next, nil
+}
+
+// updateTradingSystem replaces every field of the trading system ts.ID with ts.
+// A stale ts.Revision is rejected with a conflict error.
+func (th *TradeHandler) updateTradingSystem(client string, ts *model.TradingSystemData) (*model.TradingSystem, error) {
+	if ts.ID == 0 {
+		return nil, model.Errorf(model.CodeInvalidPayload, "TradingSystem ID is required for update")
+	}
+	if err := validateTradingSystem(ts); err != nil {
+		return nil, err
+	}
+	// Update the existing trading system fields with new data; a non-zero
+	// revision makes this a compare-and-swap against the stored revision
+	dbTrade := model.NewTradingSystem(ts)
+	dbTrade.ID = ts.ID
+	// Save the updated trading system back to the database
+	if err := th.dbs.WithClient(client).UpdateTradingSystem(dbTrade); err != nil {
+		return nil, err
+	}
+	th.publish(EventUpdated, dbTrade.Data())
+	return dbTrade, nil
+}
+
+// patchTradingSystem applies a JSON merge patch to the trading system,
+// changing only the fields present in patch.
+func (th *TradeHandler) patchTradingSystem(client string, tradeID uint, patch []byte) (*model.TradingSystem, error) {
+	dbTrade, err := th.dbs.WithClient(client).PatchTradingSystem(tradeID, patch)
+	if err != nil {
+		return nil, err
+	}
+	th.publish(EventUpdated, dbTrade.Data())
+	return dbTrade, nil
+}
+
+// appendPrices adds price points to the history of the trading system.
+func (th *TradeHandler) appendPrices(client string, tradeID uint, points []model.PricePoint) (*model.TradingSystem, error) {
+	dbTrade, err := th.dbs.WithClient(client).AppendPrices(tradeID, points)
+	if err != nil {
+		return nil, err
+	}
+	th.publish(EventUpdated, dbTrade.Data())
+	return dbTrade, nil
+}
+
+// revertTradingSystem restores the trading system to the state it had at
+// revision, as a new revision.
+func (th *TradeHandler) revertTradingSystem(client string, tradeID uint, revision uint) (*model.TradingSystem, error) {
+	dbTrade, err := th.dbs.WithClient(client).RevertTradingSystem(tradeID, revision)
+	if err != nil {
+		return nil, err
+	}
+	th.publish(EventUpdated, dbTrade.Data())
+	return dbTrade, nil
+}
+
+func (th *TradeHandler) deleteTradingSystem(client string, tradeID uint) error {
+	if tradeID == 0 {
+		return model.Errorf(model.CodeInvalidPayload, "TradingSystem ID is required for delete")
+	}
+	// Keep the last state around for the deleted event
+	existingTrade, err := th.dbs.ReadTradingSystem(tradeID)
+	if err != nil {
+		return err
+	}
+	// Delete the trading system from the database based on tradeID
+	if err := th.dbs.WithClient(client).DeleteTradingSystem(tradeID); err != nil {
+		return err
+	}
+	th.publish(EventDeleted, existingTrade.Data())
+	return nil
+}
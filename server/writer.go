@@ -0,0 +1,246 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// QueuePolicy decides what happens to a message sent to a connection whose
+// outbound queue is full, which means its client reads slower than the
+// server writes.
+type QueuePolicy int
+
+const (
+	// QueueBlock makes the sender wait for room in the queue. A client
+	// that stops reading is disconnected by the write timeout.
+	QueueBlock QueuePolicy = iota
+	// QueueDropOldest discards the oldest queued message to make room.
+	QueueDropOldest
+	// QueueDisconnect closes the connection.
+	QueueDisconnect
+)
+
+var queuePolicyNames = map[QueuePolicy]string{
+	QueueBlock:      "block",
+	QueueDropOldest: "drop-oldest",
+	QueueDisconnect: "disconnect",
+}
+
+func (p QueuePolicy) String() string {
+	if name, ok := queuePolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("QueuePolicy(%d)", int(p))
+}
+
+// ParseQueuePolicy parses the name of a queue policy: block, drop-oldest or
+// disconnect.
+func ParseQueuePolicy(s string) (QueuePolicy, error) {
+	for p, name := range queuePolicyNames {
+		if name == s {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown queue policy %q", s)
+}
+
+// Defaults of WriterConfig.
+const (
+	DefaultQueueSize    = 64
+	DefaultWriteTimeout = 10 * time.Second
+)
+
+// WriterConfig configures the outbound queue of every WebSocket connection.
+// Zero values select the defaults.
+type WriterConfig struct {
+	QueueSize    int
+	Policy       QueuePolicy
+	WriteTimeout time.Duration
+}
+
+func (c WriterConfig) withDefaults() WriterConfig {
+	if c.QueueSize <= 0 {
+		c.QueueSize = DefaultQueueSize
+	}
+	if c.WriteTimeout <= 0 {
+		c.WriteTimeout = DefaultWriteTimeout
+	}
+	return c
+}
+
+// errConnClosed is returned when writing to a connection that was closed.
+var errConnClosed = errors.New("connection closed")
+
+// writerStats counts the outbound messages of every connection of a
+// TradeHandler.
+type writerStats struct {
+	dropped     atomic.Uint64
+	disconnects atomic.Uint64
+	writeErrors atomic.Uint64
+}
+
+// frameWriter is the part of *websocket.Conn used by the writer goroutine.
+type frameWriter interface {
+	SetWriteDeadline(t time.Time) error
+	WriteMessage(messageType int, data []byte) error
+	Close() error
+}
+
+// outbound serializes the writes to a connection: messages are queued and
+// written one at a time by a single goroutine, since gorilla/websocket
+// supports only one concurrent writer.
+type outbound struct {
+	conn  frameWriter
+	cfg   WriterConfig
+	stats *writerStats
+	queue chan []byte
+
+	done      chan struct{}
+	closeOnce sync.Once
+	// goingAway is closed when the server shuts down: the queue is flushed,
+	// a close frame is written and later messages are refused.
+	goingAway  chan struct{}
+	goAwayOnce sync.Once
+	// stopped is closed when the writer goroutine returns.
+	stopped chan struct{}
+}
+
+func newOutbound(conn frameWriter, cfg WriterConfig, stats *writerStats) *outbound {
+	cfg = cfg.withDefaults()
+	o := &outbound{
+		conn:      conn,
+		cfg:       cfg,
+		stats:     stats,
+		queue:     make(chan []byte, cfg.QueueSize),
+		done:      make(chan struct{}),
+		goingAway: make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	go o.writeLoop()
+	return o
+}
+
+// send queues a message, applying the queue policy when the queue is full.
+func (o *outbound) send(p []byte) error {
+	select {
+	case <-o.done:
+		return errConnClosed
+	case <-o.goingAway:
+		return errConnClosed
+	case o.queue <- p:
+		return nil
+	default:
+	}
+	switch o.cfg.Policy {
+	case QueueDropOldest:
+		// Try to queue before each drop; the writer goroutine may have
+		// made room in the meantime.
+		for {
+			select {
+			case <-o.done:
+				return errConnClosed
+			case <-o.goingAway:
+				return errConnClosed
+			case o.queue <- p:
+				return nil
+			default:
+			}
+			select {
+			case <-o.queue:
+				o.stats.dropped.Add(1)
+			default:
+			}
+		}
+	case QueueDisconnect:
+		o.stats.dropped.Add(1)
+		o.stats.disconnects.Add(1)
+		log.Println("Closing WebSocket connection: outbound queue full")
+		o.close()
+		return errors.New("outbound queue full")
+	default:
+		select {
+		case <-o.done:
+			return errConnClosed
+		case <-o.goingAway:
+			return errConnClosed
+		case o.queue <- p:
+			return nil
+		}
+	}
+}
+
+func (o *outbound) writeLoop() {
+	defer close(o.stopped)
+	for {
+		select {
+		case <-o.done:
+			return
+		case p := <-o.queue:
+			if !o.write(websocket.TextMessage, p) {
+				return
+			}
+		case <-o.goingAway:
+			for len(o.queue) > 0 {
+				if !o.write(websocket.TextMessage, <-o.queue) {
+					return
+				}
+			}
+			o.write(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"))
+			return
+		}
+	}
+}
+
+// write writes one frame and reports whether it succeeded. A failed write
+// closes the connection.
+func (o *outbound) write(messageType int, p []byte) bool {
+	o.conn.SetWriteDeadline(time.Now().Add(o.cfg.WriteTimeout))
+	if err := o.conn.WriteMessage(messageType, p); err != nil {
+		select {
+		case <-o.done:
+			// The write failed because the connection was closed.
+		default:
+			o.stats.writeErrors.Add(1)
+			log.Println("Error writing to WebSocket:", err)
+			o.close()
+		}
+		return false
+	}
+	return true
+}
+
+// queued returns the number of messages waiting to be written.
+func (o *outbound) queued() int {
+	return len(o.queue)
+}
+
+// goAway flushes the queue and says goodbye with a close frame, leaving the
+// connection open for the client to answer it.
+func (o *outbound) goAway() {
+	o.goAwayOnce.Do(func() { close(o.goingAway) })
+}
+
+// close discards the queue and closes the connection, which also ends its
+// read loop.
+func (o *outbound) close() {
+	o.closeOnce.Do(func() {
+		close(o.done)
+		o.conn.Close()
+	})
+}
+
+// writeJSON queues v as a single WebSocket message.
+func (o *outbound) writeJSON(v interface{}) error {
+	p, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return o.send(p)
+}
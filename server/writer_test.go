@@ -0,0 +1,199 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chidi150c/database/memory"
+	"github.com/chidi150c/database/model"
+	"github.com/gorilla/websocket"
+)
+
+// stalledConn is a frameWriter whose writes block until release is closed,
+// like the socket of a client that stopped reading.
+type stalledConn struct {
+	release chan struct{}
+	closed  chan struct{}
+	once    sync.Once
+
+	mu       sync.Mutex
+	deadline time.Time
+	written  []string
+}
+
+func newStalledConn() *stalledConn {
+	return &stalledConn{release: make(chan struct{}), closed: make(chan struct{})}
+}
+
+func (c *stalledConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *stalledConn) WriteMessage(_ int, data []byte) error {
+	c.mu.Lock()
+	deadline := c.deadline
+	c.mu.Unlock()
+	select {
+	case <-c.release:
+	case <-c.closed:
+		return errConnClosed
+	case <-time.After(time.Until(deadline)):
+		return errors.New("i/o timeout")
+	}
+	c.mu.Lock()
+	c.written = append(c.written, string(data))
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *stalledConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+// fill sends messages until the writer goroutine is stuck on the first one
+// and the queue holds size more.
+func fill(t *testing.T, o *outbound, size int) {
+	t.Helper()
+	if err := o.send([]byte("0")); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	for o.queued() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 1; i <= size; i++ {
+		if err := o.send([]byte{byte('0' + i)}); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+	}
+}
+
+func TestQueueDropOldest(t *testing.T) {
+	conn := newStalledConn()
+	var stats writerStats
+	o := newOutbound(conn, WriterConfig{QueueSize: 2, Policy: QueueDropOldest, WriteTimeout: time.Minute}, &stats)
+	defer o.close()
+	fill(t, o, 2)
+
+	if err := o.send([]byte("3")); err != nil {
+		t.Fatalf("send to a full queue: %v", err)
+	}
+	if got := stats.dropped.Load(); got != 1 {
+		t.Fatalf("dropped %d messages, want 1", got)
+	}
+	close(conn.release)
+	for o.queued() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if got := len(conn.written); got != 3 || conn.written[1] != "2" || conn.written[2] != "3" {
+		t.Fatalf("wrote %q, want [0 2 3]", conn.written)
+	}
+}
+
+func TestQueueDisconnect(t *testing.T) {
+	conn := newStalledConn()
+	var stats writerStats
+	o := newOutbound(conn, WriterConfig{QueueSize: 1, Policy: QueueDisconnect, WriteTimeout: time.Minute}, &stats)
+	fill(t, o, 1)
+
+	if err := o.send([]byte("2")); err == nil {
+		t.Fatal("send to a full queue succeeded")
+	}
+	select {
+	case <-conn.closed:
+	default:
+		t.Fatal("connection was not closed")
+	}
+	if got := stats.disconnects.Load(); got != 1 {
+		t.Fatalf("counted %d disconnects, want 1", got)
+	}
+	if err := o.send([]byte("3")); err != errConnClosed {
+		t.Fatalf("send after disconnect returned %v, want %v", err, errConnClosed)
+	}
+}
+
+func TestQueueBlockWriteTimeout(t *testing.T) {
+	conn := newStalledConn()
+	var stats writerStats
+	o := newOutbound(conn, WriterConfig{QueueSize: 1, Policy: QueueBlock, WriteTimeout: 20 * time.Millisecond}, &stats)
+	fill(t, o, 1)
+
+	// The blocked sender is released when the write times out and the
+	// connection is closed.
+	if err := o.send([]byte("2")); err != errConnClosed {
+		t.Fatalf("send to a stalled connection returned %v, want %v", err, errConnClosed)
+	}
+	if got := stats.writeErrors.Load(); got != 1 {
+		t.Fatalf("counted %d write errors, want 1", got)
+	}
+}
+
+func TestGoAway(t *testing.T) {
+	conn := newStalledConn()
+	var stats writerStats
+	o := newOutbound(conn, WriterConfig{QueueSize: 2, WriteTimeout: time.Minute}, &stats)
+	defer o.close()
+	fill(t, o, 2)
+
+	o.goAway()
+	if err := o.send([]byte("3")); err != errConnClosed {
+		t.Fatalf("send after goAway returned %v, want %v", err, errConnClosed)
+	}
+	// The queued messages are written before the close frame, and the
+	// connection is left open for the client to answer it.
+	close(conn.release)
+	<-o.stopped
+	select {
+	case <-conn.closed:
+		t.Fatal("connection was closed")
+	default:
+	}
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	closeFrame := string(websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"))
+	if got := len(conn.written); got != 4 || conn.written[2] != "2" || conn.written[3] != closeFrame {
+		t.Fatalf("wrote %q, want [0 1 2 close]", conn.written)
+	}
+}
+
+func TestPublishSlowSubscriber(t *testing.T) {
+	th := NewTradeHandler(memory.NewDBServices())
+	conn := newStalledConn()
+	defer conn.Close()
+	var stats writerStats
+	slow := newWSConn(conn, "slow", WriterConfig{QueueSize: 1, WriteTimeout: time.Minute}, &stats)
+	defer slow.out.close()
+	slow.all = true
+	th.connections.m[slow] = struct{}{}
+	fill(t, slow.out, 1)
+
+	published := make(chan struct{})
+	go func() {
+		th.publish(EventUpdated, &model.TradingSystemData{ID: 1})
+		close(published)
+	}()
+	// The publish gets stuck on the full queue, but connections can still
+	// come and go.
+	time.Sleep(20 * time.Millisecond)
+	locked := make(chan struct{})
+	go func() {
+		th.connections.Lock()
+		th.connections.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("publish to a slow subscriber holds the connections lock")
+	}
+	close(conn.release)
+	<-published
+}
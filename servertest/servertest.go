@@ -0,0 +1,93 @@
+// Package servertest runs a TradeHandler in process for end-to-end tests of
+// the WebSocket protocol, the way net/http/httptest does for handlers.
+package servertest
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chidi150c/database/client"
+	"github.com/chidi150c/database/gorm"
+	"github.com/chidi150c/database/server"
+)
+
+// Timeout bounds how long Dial waits for the connection. Tests bound their
+// calls and the events they wait for with it too.
+var Timeout = 5 * time.Second
+
+// Server is a TradeHandler listening on a local httptest.Server, backed by a
+// migrated SQLite database in a temporary directory.
+type Server struct {
+	*httptest.Server
+	Handler *server.TradeHandler
+	DBS     *gorm.DBServices
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+// NewServer starts a Server that is shut down, and its database removed, when
+// the test ends.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	dbs, err := gorm.NewDBServices(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	if err := dbs.Migrate(); err != nil {
+		dbs.Close()
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	th := server.NewTradeHandler(dbs)
+	s := &Server{Server: httptest.NewUnstartedServer(th), Handler: th, DBS: dbs}
+	// httptest forgets hijacked connections, so WebSocket connections are
+	// tracked here for DropConnections.
+	s.Config.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		s.mu.Lock()
+		s.conns = append(s.conns, c)
+		s.mu.Unlock()
+		return ctx
+	}
+	s.Start()
+	t.Cleanup(func() {
+		s.Close()
+		dbs.Close()
+	})
+	return s
+}
+
+// DropConnections abruptly closes every connection accepted so far,
+// including upgraded WebSocket connections, to simulate a network failure.
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.conns = nil
+}
+
+// WebSocketURL returns the WebSocket URL of the server.
+func (s *Server) WebSocketURL() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http") + "/database-services/ws"
+}
+
+// Dial connects a client.Client to the server. It is closed when the test
+// ends.
+func (s *Server) Dial(t testing.TB) *client.Client {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	c, err := client.Dial(ctx, client.Config{URL: s.WebSocketURL()})
+	if err != nil {
+		t.Fatalf("Failed to connect to WebSocket: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}